@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	// acRules is the udev rule installed by `threshold --on-ac-only`, which
+	// reapplies the thresholds whenever a power supply comes or goes.
+	acRules = filepath.Join("/", "etc", "udev", "rules.d", "90-bat-on-ac-only.rules")
+
+	//go:embed aconly.rules
+	acRule string
+
+	// acExpected matches the thresholds passed to on-ac in the rule.
+	acExpected = regexp.MustCompile(` on-ac (\S+)"`)
+)
+
+// installACOnly sets the charging threshold of every battery that has one
+// to value while on external power and to 100 otherwise, and installs a
+// udev rule that does so again whenever the power source changes.
+func installACOnly(ctx context.Context, batteries []*Device, value int) error {
+	expected := make([]string, 0, len(batteries))
+	for _, b := range batteries {
+		if b.Capabilities()&HasThreshold == 0 {
+			continue
+		}
+		expected = append(expected, b.Name+"="+strconv.Itoa(value))
+	}
+	if len(expected) == 0 {
+		return errNoThreshold
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	v := struct{ Path, Expected string }{Path: path, Expected: strings.Join(expected, ",")}
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("rule").Parse(acRule))
+	if err := tmpl.Execute(&buf, v); err != nil {
+		return err
+	}
+	if err := os.WriteFile(acRules, buf.Bytes(), 0o644); err != nil {
+		os.Remove(acRules)
+		return err
+	}
+	if err := relabel(ctx, acRules); err != nil {
+		return err
+	}
+	if err := reloadRules(ctx); err != nil {
+		return err
+	}
+	return applyOnAC(batteries, v.Expected)
+}
+
+// removeACOnly removes the rule installed by installACOnly, if any, and
+// reports whether it was installed. The thresholds are left as they are.
+func removeACOnly(ctx context.Context) (bool, error) {
+	if err := os.Remove(acRules); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, reloadRules(ctx)
+}
+
+// reloadRules asks udev to reload its rules. udev notices changed rules
+// on its own on most systems, so its absence is not an error.
+func reloadRules(ctx context.Context) error {
+	output, err := runner.Run(exec.CommandContext(ctx, "udevadm", "control", "--reload"))
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", bytes.TrimSpace(output), err)
+	}
+	return nil
+}
+
+// acOnly returns the thresholds, in the form BAT0=80,BAT1=90, applied
+// only on external power if `threshold --on-ac-only` is in effect, or the
+// empty string otherwise.
+func acOnly() (string, error) {
+	contents, err := os.ReadFile(acRules)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	m := acExpected.FindSubmatch(contents)
+	if m == nil {
+		return "", fmt.Errorf("%s: thresholds not found", acRules)
+	}
+	return string(m[1]), nil
+}
+
+// applyOnAC sets each battery in expected, given in the form
+// BAT0=80,BAT1=90, to its threshold while on external power and to 100
+// on battery power. Batteries that are missing are skipped since the
+// rule also runs while devices come and go.
+func applyOnAC(batteries []*Device, expected string) error {
+	srcs, err := sources()
+	if err != nil {
+		return err
+	}
+	plugged := online(srcs) != ""
+	for _, pair := range strings.Split(expected, ",") {
+		name, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("malformed expectation %q", pair)
+		}
+		value, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		d, ok := device(batteries, name)
+		if !ok {
+			continue
+		}
+		if !plugged {
+			value = 100
+		}
+		if err := d.set(threshold, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onAC fills in the thresholds applied only on external power, if any,
+// so that restoring the settings read on battery power does not undo
+// `threshold --on-ac-only`.
+func (s *Service) onAC() error {
+	expected, err := acOnly()
+	if err != nil || expected == "" {
+		return err
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	s.OnAC, s.Path = expected, path
+	return nil
+}
+
+// cmdOnAC applies the thresholds held only on external power, for the udev
+// rule.
+func cmdOnAC(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	// Invoked by the rule installed with `threshold --on-ac-only` and
+	// the persistence units.
+	if len(args) != 1 {
+		return errUsage
+	}
+	return applyOnAC(batteries, args[0])
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// acpi holds the batteries of the legacy ACPI interface, which kernels
+// before 2.6.24 offer in place of the power_supply class and later ones
+// with CONFIG_ACPI_PROCFS_POWER. It has no charging threshold.
+var acpi = filepath.Join("/", "proc", "acpi", "battery")
+
+// legacy maps each power_supply variable to the file and field of the
+// legacy interface holding it. Capacities are given in mAh or mWh,
+// depending on the battery, which decides between the charge and energy
+// variables.
+var legacy = map[string]struct{ file, field string }{
+	"present":            {"info", "present"},
+	"status":             {"state", "charging state"},
+	"capacity":           {"state", "remaining capacity"},
+	"charge_full":        {"info", "last full capacity"},
+	"charge_full_design": {"info", "design capacity"},
+	"charge_now":         {"state", "remaining capacity"},
+	"current_now":        {"state", "present rate"},
+	"energy_full":        {"info", "last full capacity"},
+	"energy_full_design": {"info", "design capacity"},
+	"energy_now":         {"state", "remaining capacity"},
+	"power_now":          {"state", "present rate"},
+	"voltage_now":        {"state", "present voltage"},
+	"voltage_min_design": {"info", "design voltage"},
+	"model_name":         {"info", "model number"},
+	"serial_number":      {"info", "serial number"},
+	"manufacturer":       {"info", "OEM info"},
+	"technology":         {"info", "battery type"},
+}
+
+// procFS presents the batteries under acpi as if they were power_supply
+// devices, so that the level, status and health can be read on systems
+// without the power_supply class. Other paths are passed through to FS.
+type procFS struct {
+	FS
+}
+
+// variable returns the battery directory and variable name of path if it
+// names a variable of a legacy battery.
+func (procFS) variable(path string) (string, string, bool) {
+	dir, name := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	if filepath.Dir(dir) != acpi {
+		return "", "", false
+	}
+	return dir, name, true
+}
+
+// fields parses a file of the legacy interface, made of "name: value"
+// lines.
+func (p procFS) fields(path string) (map[string]string, error) {
+	contents, err := p.FS.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok {
+			fields[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return fields, scanner.Err()
+}
+
+// value returns the contents that the power_supply class would give the
+// variable of the battery in dir.
+func (p procFS) value(dir, variable string) (string, error) {
+	if variable == "type" {
+		return "Battery", nil
+	}
+	source, ok := legacy[variable]
+	if !ok {
+		return "", fs.ErrNotExist
+	}
+	fields, err := p.fields(filepath.Join(dir, source.file))
+	if err != nil {
+		return "", err
+	}
+	v, ok := fields[source.field]
+	if !ok || v == "unknown" {
+		return "", fs.ErrNotExist
+	}
+
+	switch variable {
+	case "present":
+		if v == "yes" {
+			return "1", nil
+		}
+		return "0", nil
+	case "status":
+		switch v {
+		case "charging":
+			return "Charging", nil
+		case "discharging":
+			return "Discharging", nil
+		case "charged":
+			return "Full", nil
+		}
+		return "Unknown", nil
+	case "model_name", "serial_number", "manufacturer", "technology":
+		return v, nil
+	}
+
+	// Readings are reported in milli units, with the unit deciding
+	// whether the charge or energy variables apply.
+	number, unit, _ := strings.Cut(v, " ")
+	charge := strings.HasPrefix(variable, "charge_") || variable == "current_now"
+	energy := strings.HasPrefix(variable, "energy_") || variable == "power_now"
+	if (charge && !strings.HasPrefix(unit, "mA")) || (energy && !strings.HasPrefix(unit, "mW")) {
+		return "", fs.ErrNotExist
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return "", err
+	}
+	if variable != "capacity" {
+		return strconv.Itoa(n * 1000), nil
+	}
+	full, err := p.value(dir, "charge_full")
+	if err != nil {
+		full, err = p.value(dir, "energy_full")
+	}
+	if err != nil {
+		return "", err
+	}
+	max, err := strconv.Atoi(full)
+	if err != nil {
+		return "", err
+	}
+	if max == 0 {
+		return "", fs.ErrNotExist
+	}
+	return strconv.Itoa(min(n*1000*100/max, 100)), nil
+}
+
+func (p procFS) Stat(name string) (fs.FileInfo, error) {
+	dir, variable, ok := p.variable(name)
+	if !ok {
+		return p.FS.Stat(name)
+	}
+	if _, err := p.value(dir, variable); err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return p.FS.Stat(filepath.Join(dir, legacy[variable].file))
+}
+
+func (p procFS) ReadFile(name string) ([]byte, error) {
+	dir, variable, ok := p.variable(name)
+	if !ok {
+		return p.FS.ReadFile(name)
+	}
+	v, err := p.value(dir, variable)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return []byte(v + "\n"), nil
+}
+
+// legacyDevice reports whether d is read through the legacy interface.
+func legacyDevice(d *Device) bool {
+	return d != nil && filepath.Dir(d.root) == acpi
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+)
+
+var errNoCapacity = errors.New("full capacity not found")
+
+// capacity returns the full capacity in the unit (µAh or µWh) of the
+// alarm, which is expressed as a share of it. errNoCapacity is returned if
+// the battery reports none, or 0, as some firmware does while calibrating
+// or once the battery has been removed.
+func (b *battery) capacity() (int, error) {
+	full := "charge_full"
+	ok, err := b.has(full)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		full = "energy_full"
+	}
+	capacity, err := b.readInt(full)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && capacity <= 0 {
+		return 0, errNoCapacity
+	}
+	return capacity, err
+}
+
+// alarm returns the level at which the battery raises an alarm as a
+// percentage of its full capacity.
+func (b *battery) alarm() (int, error) {
+	capacity, err := b.capacity()
+	if err != nil {
+		return 0, err
+	}
+	alarm, err := b.readInt("alarm")
+	if err != nil {
+		return 0, err
+	}
+	return alarm * 100 / capacity, nil
+}
+
+// cmdAlarm prints the level at which the firmware raises a low battery
+// alarm, or sets it if a level is given.
+func cmdAlarm(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	if bat.Capabilities()&HasAlarm == 0 {
+		return userError(unsupported(bat, HasAlarm))
+	}
+	// The alarm is expressed in the same unit (µAh or µWh) as the
+	// full capacity so it is converted to and from a percentage of it.
+	capacity, err := bat.capacity()
+	if errors.Is(err, errNoCapacity) {
+		return userError(unsupported(bat, HasAlarm))
+	}
+	if err != nil {
+		panic(err)
+	}
+	switch len(args) {
+	case 0:
+		// Get.
+		alarm, err := bat.alarm()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(alarm)
+	case 1:
+		// Set.
+		i, err := strconv.Atoi(args[0])
+		if err != nil {
+			if errors.Is(err, strconv.ErrSyntax) {
+				return userError("Argument should be an integer.")
+			}
+			panic(err)
+		}
+		if i < 0 || i > 100 {
+			return userError("Alarm value should be between 0 and 100.")
+		}
+		alarm := strconv.Itoa(capacity * i / 100)
+		if err := bat.write("alarm", []byte(alarm)); err != nil {
+			return err
+		}
+		fmt.Println("Alarm level set.")
+	default:
+		return errUsage
+	}
+	return nil
+}
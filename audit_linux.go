@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// changes records the threshold changes made through bat, so that admins
+// of shared machines can tell who last changed it. It is shared by every
+// user and only written by root, through the helper or daemon for others.
+var changes = filepath.Join("/", "var", "lib", "bat", "changes")
+
+// Change summarises the threshold changes made to a battery.
+type Change struct {
+	Count int
+	Time  time.Time
+	UID   int
+	Value int
+}
+
+// loadChanges returns the changes recorded for each battery by name. Each
+// line holds the name, count, Unix time, user ID and value separated by
+// tabs.
+func loadChanges() (map[string]Change, error) {
+	cs := make(map[string]Change)
+	f, err := os.Open(changes)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cs, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("%s: malformed line %q", changes, scanner.Text())
+		}
+		values := make([]int, 4)
+		for i, field := range fields[1:] {
+			if values[i], err = strconv.Atoi(field); err != nil {
+				return nil, fmt.Errorf("%s: %w", changes, err)
+			}
+		}
+		cs[fields[0]] = Change{Count: values[0], Time: time.Unix(int64(values[1]), 0), UID: values[2], Value: values[3]}
+	}
+	return cs, scanner.Err()
+}
+
+// recordChange counts a change of the threshold of the battery to value by
+// the user.
+func recordChange(name string, uid, value int, now time.Time) error {
+	cs, err := loadChanges()
+	if err != nil {
+		return err
+	}
+	c := cs[name]
+	cs[name] = Change{Count: c.Count + 1, Time: now, UID: uid, Value: value}
+	names := make([]string, 0, len(cs))
+	for name := range cs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		c := cs[name]
+		fmt.Fprintf(&b, "%s\t%d\t%d\t%d\t%d\n", name, c.Count, c.Time.Unix(), c.UID, c.Value)
+	}
+	if err := os.MkdirAll(filepath.Dir(changes), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(changes, []byte(b.String()), 0o644)
+}
+
+// invoker returns the ID of the user running bat, looking through sudo.
+func invoker() int {
+	if uid, err := strconv.Atoi(os.Getenv("SUDO_UID")); err == nil && os.Getuid() == 0 {
+		return uid
+	}
+	return os.Getuid()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var errNotDischarging = errors.New("battery is not discharging")
+
+// Result is the outcome of a benchmark run.
+type Result struct {
+	Time   time.Time
+	Label  string
+	Window time.Duration
+	// Watts is the average drain and Runtime the time a full battery would
+	// last at that rate, or zero if the full energy is not exposed.
+	Watts   float64
+	Runtime time.Duration
+}
+
+// energy returns the energy of a full battery in watt-hours, or false if
+// it cannot be determined. Devices reporting charge are converted using
+// the design minimum voltage.
+func (b *battery) energy() (float64, bool, error) {
+	read := func(variable string) (int, bool, error) {
+		i, err := b.readInt(variable)
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, false, nil
+		}
+		return i, err == nil, err
+	}
+	microwatthours, ok, err := read("energy_full")
+	if err != nil || ok {
+		return float64(microwatthours) / 1e6, ok, err
+	}
+	microamphours, ok, err := read("charge_full")
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	microvolts, ok, err := read("voltage_min_design")
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return float64(microamphours) / 1e6 * float64(microvolts) / 1e6, true, nil
+}
+
+// benchmark samples the drain of the battery every second over the window
+// and returns the average. The battery must be discharging throughout.
+func (b *battery) benchmark(ctx context.Context, window time.Duration) (Result, error) {
+	r := Result{Time: time.Now(), Window: window}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.After(window)
+	var total float64
+	n := 0
+	for {
+		watts, discharging, err := b.drain()
+		if err != nil {
+			return r, err
+		}
+		if !discharging {
+			return r, errNotDischarging
+		}
+		total += watts
+		n++
+		select {
+		case <-ctx.Done():
+			return r, ctx.Err()
+		case <-deadline:
+			r.Watts = total / float64(n)
+			energy, ok, err := b.energy()
+			if err != nil {
+				return r, err
+			}
+			if ok && r.Watts > 0 {
+				r.Runtime = hours(energy / r.Watts)
+			}
+			return r, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// results returns the path of the file in which benchmark results are
+// kept.
+func results() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "benchmarks"), nil
+}
+
+// loadResults returns the stored benchmark results in the order they were
+// taken.
+func loadResults() ([]Result, error) {
+	path, err := results()
+	if err != nil {
+		return nil, err
+	}
+	return loadLines(path, func(line string) (Result, bool) {
+		var (
+			seconds int64
+			r       Result
+		)
+		// Labels are free text so they come last.
+		fields := strings.SplitN(line, "\t", 5)
+		if len(fields) != 5 {
+			return r, false
+		}
+		if _, err := fmt.Sscanf(strings.Join(fields[:4], " "), "%d %d %g %d", &seconds, &r.Window, &r.Watts, &r.Runtime); err != nil {
+			return r, false
+		}
+		r.Time, r.Label = time.Unix(seconds, 0), fields[4]
+		return r, true
+	})
+}
+
+// save appends the result to the stored results.
+func (r Result) save() error {
+	rs, err := loadResults()
+	if err != nil {
+		return err
+	}
+	return saveResults(append(rs, r))
+}
+
+// saveResults replaces the stored results.
+func saveResults(rs []Result) error {
+	path, err := results()
+	if err != nil {
+		return err
+	}
+	return saveLines(path, rs, func(r Result) string {
+		label := strings.NewReplacer("\t", " ", "\n", " ").Replace(r.Label)
+		return fmt.Sprintf("%d\t%d\t%g\t%d\t%s", r.Time.Unix(), r.Window, r.Watts, r.Runtime, label)
+	})
+}
+
+// cmdBenchmark measures the drain over a window and records the result, or
+// compares those recorded.
+func cmdBenchmark(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("benchmark", flag.ExitOnError)
+	window := flags.Duration("window", 5*time.Minute, ignore)
+	label := flags.String("label", "", ignore)
+	list := flags.Bool("list", false, ignore)
+	since := flags.String("since", "", ignore)
+	until := flags.String("until", "", ignore)
+	last := flags.String("last", "", ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	if !*list && *since+*until+*last != "" {
+		return userError("The --since, --until and --last flags only apply to --list.")
+	}
+	if *list {
+		span, err := historyRange(*since, *until, *last)
+		if err != nil {
+			return err
+		}
+		rs, err := loadResults()
+		if err != nil {
+			panic(err)
+		}
+		t := Table{Header: []string{"DATE", "LABEL", "WINDOW", "DRAIN", "RUNTIME"}, Right: []int{2, 3, 4}}
+		for _, r := range rs {
+			if !span.contains(r.Time) {
+				continue
+			}
+			runtime := "-"
+			if r.Runtime > 0 {
+				runtime = formatDuration(r.Runtime, "short", "", r.Time)
+			}
+			t.Append(r.Time.Format("2006-01-02 15:04"), r.Label, r.Window.String(), numbers.Watts(r.Watts), runtime)
+		}
+		if err := t.Render(os.Stdout); err != nil {
+			panic(err)
+		}
+		return nil
+	}
+	if *window <= 0 {
+		return userError("The window should be positive.")
+	}
+	if bat.Capabilities()&HasPowerReadings == 0 {
+		return userError(unsupported(bat, HasPowerReadings))
+	}
+	// Comparing kernels is the most common use so results are labelled
+	// with the release by default.
+	if *label == "" {
+		var utsname unix.Utsname
+		if err := unix.Uname(&utsname); err != nil {
+			panic(err)
+		}
+		*label = unix.ByteSliceToString(utsname.Release[:])
+	}
+	fmt.Printf("Sampling the drain for %s. Leave the machine idle and unplugged.\n", *window)
+	r, err := bat.benchmark(ctx, *window)
+	if err != nil {
+		if errors.Is(err, errNotDischarging) {
+			return userError("The battery should be discharging throughout. Unplug the charger and\n" +
+				"try again.")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	r.Label = *label
+	if err := r.save(); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Average drain: %s\n", numbers.Watts(r.Watts))
+	if r.Runtime > 0 {
+		fmt.Printf("Projected runtime: %s\n", formatDuration(r.Runtime, "short", "", r.Time))
+	}
+	return nil
+}
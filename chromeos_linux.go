@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+var chromeos = filepath.Join("/", "sys", "class", "chromeos")
+
+// missing returns the message shown when d does not expose the charging
+// threshold. Chromebooks and Framework laptops only expose it through the
+// ChromeOS embedded controller driver, which attaches the standard
+// variables to the battery once loaded, so point users there.
+func missing(d *Device) string {
+	if _, err := sysfs.Stat(filepath.Join(chromeos, "cros_ec")); err == nil {
+		return "Charging threshold setting not found. On Chromebooks and Framework laptops\n" +
+			"this requires Linux 6.12 or later with the `cros_charge-control` module\n" +
+			"loaded."
+	}
+	if settings, err := hpSettings(); err == nil && len(settings) > 0 {
+		return fmt.Sprintf("Charging threshold setting not found. HP laptops manage charging in\n"+
+			"firmware instead, where %s is set to %q.\n"+
+			"Run `bat hp-charging` to inspect or change it.", settings[0].Name, settings[0].Current)
+	}
+	if legacyDevice(d) {
+		return "Charging threshold setting not found. The battery is read through the\n" +
+			"legacy /proc/acpi interface, which is read-only."
+	}
+	return unsupported(d, HasThreshold)
+}
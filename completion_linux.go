@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// options lists the flags accepted by each command, and the values
+// accepted by flags and commands whose arguments are a fixed set. Flags
+// followed by "=" take a value.
+var options = map[string][]string{
+	"alarm":          nil,
+	"apply":          {"--from-config"},
+	"benchmark":      {"--window=", "--label=", "--list", "--since=", "--until=", "--last="},
+	"capacity":       {"--threshold-relative", "--absolute", "--below=", "--above="},
+	"completion":     {"--dynamic"},
+	"config":         {"--effective"},
+	"daemon":         {"--socket=", "--interval=", "--battery-interval=", "--group=", "--source-policy=", "--log-level=", "--log-format=", "--enforce", "--exec=", "--exec-at=", "--dbus-signal", "--inhibit-sleep-below=", "--critical-action=", "--replace"},
+	"doctor":         {"--fix", "--yes", "--markdown"},
+	"events":         {"--json", "--follow", "--once", "--at="},
+	"full-charge-at": {"--for="},
+	"health":         {"--trend", "--since=", "--until=", "--last="},
+	"helper":         nil,
+	"history":        {"--days=", "--label="},
+	"hp-charging":    nil,
+	"id":             nil,
+	"input-limit":    {"--source="},
+	"line":           nil,
+	"metrics":        {"--format="},
+	"panic-restore":  {"--socket="},
+	"peripherals":    nil,
+	"persist":        {"--now", "--method=", "--unit-dir=", "--unit-prefix=", "--offline", "--root="},
+	"remaining":      {"--time-format="},
+	"reset":          {"--unit-dir=", "--unit-prefix="},
+	"run":            nil,
+	"simulate-drain": {"--root=", "--from=", "--to=", "--limit=", "--interval=", "--charge"},
+	"source":         nil,
+	"state":          {"--check", "--device=", "--threshold=", "--start=", "--persistence="},
+	"status":         {"--explain"},
+	"temperature":    nil,
+	"threshold":      {"--each=", "--ask", "--start=", "--end=", "--verify-after-resume", "--when-full-discharge-to=", "--list-supported", "--verbose", "--on-ac-only", "--slider"},
+	"top":            {"--window=", "--limit="},
+	"vacation":       {"--threshold="},
+	"voltage":        nil,
+	"which":          {"--unit-dir=", "--unit-prefix=", "--markdown"},
+}
+
+var (
+	globals = []string{"--debug", "--help", "--output=", "--append", "--version", "--json", "--wait-for-device=", "--strict", "--config="}
+	values  = map[string][]string{
+		"--format":          {"prometheus", "influx"},
+		"--log-format":      {"text", "json"},
+		"--critical-action": {"warn", "suspend", "hibernate"},
+		"--log-level":       {"debug", "info", "warn", "error"},
+		"--method":          {"auto", "sysext"},
+		"--time-format":     {"short", "iso", "clock"},
+		"--persistence":     {"none", "auto", "systemd", "elogind", "sysext"},
+		"completion":        {"bash", "zsh", "fish"},
+		"config":            {"show", "validate"},
+		"helper":            {"install", "remove"},
+		"history":           {"prune", "import"},
+		"state":             {"apply"},
+		"vacation":          {"on", "off"},
+	}
+)
+
+// commands returns the names of the commands in alphabetical order.
+func commands() []string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// complete returns the candidates for the last of the words following the
+// program name, which is the one being completed. Unlike the fixed lists
+// above, device names and benchmark labels are looked up when asked for so
+// that completions follow the machine they run on.
+func complete(batteries []*Device, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current, previous := words[len(words)-1], words[:len(words)-1]
+
+	// The command is the first word that is neither a flag nor the value
+	// of a global flag.
+	var command string
+	for i := 0; i < len(previous); i++ {
+		word := previous[i]
+		if slices.Contains(globals, word+"=") && !strings.Contains(word, "=") {
+			i++
+			continue
+		}
+		if !strings.HasPrefix(word, "-") {
+			command = word
+			break
+		}
+	}
+
+	var candidates []string
+	switch {
+	case command == "":
+		candidates = commands()
+		if strings.HasPrefix(current, "-") {
+			candidates = globals
+		}
+	case len(previous) > 0 && slices.Contains(options[command], previous[len(previous)-1]+"="):
+		candidates = argument(batteries, previous[len(previous)-1])
+	case strings.HasPrefix(current, "-"):
+		candidates = options[command]
+	case previous[len(previous)-1] == command:
+		candidates = values[command]
+	}
+
+	matches := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if !strings.HasPrefix(c, current) {
+			continue
+		}
+		if strings.HasPrefix(c, "-") {
+			c = strings.TrimSuffix(c, "=")
+		}
+		matches = append(matches, c)
+	}
+	return matches
+}
+
+// argument returns the candidates for the value of flag.
+func argument(batteries []*Device, flag string) []string {
+	switch flag {
+	case "--each":
+		names := make([]string, 0, len(batteries))
+		for _, d := range batteries {
+			names = append(names, d.Name+"=")
+		}
+		return names
+	case "--label":
+		rs, err := loadResults()
+		if err != nil {
+			return nil
+		}
+		var labels []string
+		for _, r := range rs {
+			if !slices.Contains(labels, r.Label) {
+				labels = append(labels, r.Label)
+			}
+		}
+		return labels
+	}
+	return values[flag]
+}
+
+// completion writes a completion script for shell. Static scripts embed the
+// commands and flags of this version, while dynamic ones ask the program
+// for candidates through the hidden __complete command each time.
+func completion(w io.Writer, shell string, dynamic bool) error {
+	if dynamic {
+		switch shell {
+		case "bash":
+			_, err := io.WriteString(w, `_bat() {
+	local IFS=$'\n'
+	COMPREPLY=($(bat __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+	# Device names complete to BAT0= and are followed by a value.
+	[[ $COMPREPLY == *= ]] && compopt -o nospace
+}
+complete -F _bat bat
+`)
+			return err
+		case "zsh":
+			_, err := io.WriteString(w, `#compdef bat
+_bat() {
+	local -a candidates
+	candidates=("${(@f)$(bat __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	compadd -a candidates
+}
+compdef _bat bat
+`)
+			return err
+		case "fish":
+			_, err := io.WriteString(w, "complete -c bat -f -a '(bat __complete (commandline -opc)[2..] (commandline -ct) 2>/dev/null)'\n")
+			return err
+		}
+		return fmt.Errorf("unsupported shell %q", shell)
+	}
+
+	switch shell {
+	case "bash":
+		var b strings.Builder
+		b.WriteString("_bat() {\n\tlocal cur=${COMP_WORDS[COMP_CWORD]}\n")
+		fmt.Fprintf(&b, "\tif ((COMP_CWORD == 1)); then\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\treturn\n\tfi\n", strings.Join(commands(), " "))
+		b.WriteString("\tcase ${COMP_WORDS[1]} in\n")
+		for _, name := range commands() {
+			fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", name, words(name))
+		}
+		b.WriteString("\tesac\n}\ncomplete -F _bat bat\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	case "zsh":
+		var b strings.Builder
+		b.WriteString("#compdef bat\n_bat() {\n")
+		fmt.Fprintf(&b, "\tif ((CURRENT == 2)); then\n\t\tcompadd -- %s\n\t\treturn\n\tfi\n", strings.Join(commands(), " "))
+		b.WriteString("\tcase $words[2] in\n")
+		for _, name := range commands() {
+			fmt.Fprintf(&b, "\t%s) compadd -- %s ;;\n", name, words(name))
+		}
+		b.WriteString("\tesac\n}\ncompdef _bat bat\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	case "fish":
+		var b strings.Builder
+		fmt.Fprintf(&b, "complete -c bat -f -n __fish_use_subcommand -a %q\n", strings.Join(commands(), " "))
+		for _, name := range commands() {
+			if s := words(name); s != "" {
+				fmt.Fprintf(&b, "complete -c bat -f -n '__fish_seen_subcommand_from %s' -a %q\n", name, s)
+			}
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	return fmt.Errorf("unsupported shell %q", shell)
+}
+
+// words returns the flags and fixed arguments of command separated by
+// spaces.
+func words(command string) string {
+	var ws []string
+	for _, o := range options[command] {
+		ws = append(ws, strings.TrimSuffix(o, "="))
+	}
+	ws = append(ws, values[command]...)
+	return strings.Join(ws, " ")
+}
+
+// cmdCompletion prints the completion script for a shell.
+func cmdCompletion(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("completion", flag.ExitOnError)
+	dynamic := flags.Bool("dynamic", false, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	if err := completion(os.Stdout, flags.Arg(0), *dynamic); err != nil {
+		return userError("Completion is available for `bash`, `zsh` and `fish`.")
+	}
+	return nil
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultConfig is the system-wide configuration file.
+const defaultConfig = "/etc/bat/config.toml"
+
+var errConfig = errors.New("invalid configuration")
+
+// configFile is the configuration file given with --config, which is read
+// instead of those found by configFiles.
+var configFile string
+
+// Config is the desired state read from a configuration file.
+type Config struct {
+	// Device is the name of the battery to configure, or the first one if
+	// empty.
+	Device string
+	// Threshold is the charging threshold, or zero if unset.
+	Threshold int
+	// Start is the level below which charging resumes, or -1 if unset.
+	Start int
+	// Persist is whether the settings should survive restarts.
+	Persist bool
+	// LogLevel and LogFormat configure the logs of the daemon.
+	LogLevel, LogFormat string
+	// HistoryDays is how long the health history and benchmark results
+	// are kept by history prune, or zero to keep them indefinitely.
+	HistoryDays int
+	// MaxChargeTemp is the battery temperature in degrees Celsius above
+	// which the daemon warns about charging, or zero to disable the check,
+	// and PauseWhenHot whether it also pauses charging until it cools.
+	MaxChargeTemp int
+	PauseWhenHot  bool
+	// Saver switches the power profile when the battery runs low.
+	Saver Saver
+	// VacationThreshold is the threshold set by `vacation on`.
+	VacationThreshold int
+	// TimeFormat is the default format of remaining, one of timeFormats,
+	// and Clock whether its clock format is read in 12 or 24 hours, or
+	// empty to follow the locale.
+	TimeFormat, Clock string
+}
+
+// newConfig returns the configuration that applies when no file sets
+// anything.
+func newConfig() Config {
+	return Config{Start: -1, Persist: true, LogLevel: "info", LogFormat: "text", VacationThreshold: 60, TimeFormat: "short"}
+}
+
+// loadConfig reads the configuration file at path. Only the flat subset of
+// TOML made up of key = value pairs and comments is accepted.
+func loadConfig(path string) (Config, error) {
+	c := newConfig()
+	_, err := c.read(path)
+	return c, err
+}
+
+// uncomment returns the line without its comment, which starts at the
+// first # outside a quoted string.
+func uncomment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			// The escaped character, which may be a quote, is skipped.
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// read sets the keys found in the configuration file at path, leaving the
+// others as they are, and returns the keys it set.
+func (c *Config) read(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(uncomment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return keys, fmt.Errorf("%s:%d: expected key = value: %w", path, n, errConfig)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "device":
+			c.Device, err = strconv.Unquote(value)
+		case "threshold":
+			c.Threshold, err = strconv.Atoi(value)
+			if err == nil && (c.Threshold < 1 || c.Threshold > 100) {
+				err = errors.New("should be between 1 and 100")
+			}
+		case "start":
+			c.Start, err = strconv.Atoi(value)
+			if err == nil && (c.Start < 0 || c.Start > 100) {
+				err = errors.New("should be between 0 and 100")
+			}
+		case "persist":
+			c.Persist, err = strconv.ParseBool(value)
+		case "log_level":
+			c.LogLevel, err = strconv.Unquote(value)
+		case "log_format":
+			c.LogFormat, err = strconv.Unquote(value)
+		case "max_charge_temp":
+			c.MaxChargeTemp, err = strconv.Atoi(value)
+			if err == nil && (c.MaxChargeTemp <= cooldown || c.MaxChargeTemp > 100) {
+				err = errors.New("should be between 6 and 100")
+			}
+		case "pause_when_hot":
+			c.PauseWhenHot, err = strconv.ParseBool(value)
+		case "saver_level":
+			c.Saver.Level, err = strconv.Atoi(value)
+			if err == nil && (c.Saver.Level < 0 || c.Saver.Level > 100) {
+				err = errors.New("should be between 0 and 100")
+			}
+		case "saver_on":
+			c.Saver.On, err = strconv.Unquote(value)
+		case "saver_off":
+			c.Saver.Off, err = strconv.Unquote(value)
+		case "vacation_threshold":
+			c.VacationThreshold, err = strconv.Atoi(value)
+			if err == nil && (c.VacationThreshold < 1 || c.VacationThreshold > 100) {
+				err = errors.New("should be between 1 and 100")
+			}
+		case "time_format":
+			c.TimeFormat, err = strconv.Unquote(value)
+			if err == nil && !slices.Contains(timeFormats[:], c.TimeFormat) {
+				err = errors.New("should be one of short, iso or clock")
+			}
+		case "clock":
+			c.Clock, err = strconv.Unquote(value)
+			if err == nil && !slices.Contains(clocks[:], c.Clock) {
+				err = errors.New("should be 12h or 24h")
+			}
+		case "history_days":
+			c.HistoryDays, err = strconv.Atoi(value)
+			if err == nil && c.HistoryDays < 0 {
+				err = errors.New("should not be negative")
+			}
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil {
+			return keys, fmt.Errorf("%s:%d: %s: %v: %w", path, n, key, err, errConfig)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+// override applies the environment variables, which take precedence over
+// the configuration file but not over flags, and records them as the
+// sources of the keys they set if sources is not nil.
+func (c *Config) override(sources map[string]string) error {
+	if name := os.Getenv("BAT_DEVICE"); name != "" {
+		c.Device = name
+		if sources != nil {
+			sources["device"] = "BAT_DEVICE"
+		}
+	}
+	if v := os.Getenv("BAT_THRESHOLD"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 1 || i > 100 {
+			return fmt.Errorf("BAT_THRESHOLD should be between 1 and 100: %w", errConfig)
+		}
+		c.Threshold = i
+		if sources != nil {
+			sources["threshold"] = "BAT_THRESHOLD"
+		}
+	}
+	return nil
+}
+
+// configKeys lists the keys of the configuration file in the order they
+// are shown.
+var configKeys = [...]string{
+	"device", "threshold", "start", "persist", "log_level", "log_format",
+	"history_days", "max_charge_temp", "pause_when_hot", "saver_level",
+	"saver_on", "saver_off", "vacation_threshold", "time_format", "clock",
+}
+
+// value returns the value of key in the syntax of the configuration file.
+func (c Config) value(key string) string {
+	switch key {
+	case "device":
+		return strconv.Quote(c.Device)
+	case "threshold":
+		return strconv.Itoa(c.Threshold)
+	case "start":
+		return strconv.Itoa(c.Start)
+	case "persist":
+		return strconv.FormatBool(c.Persist)
+	case "log_level":
+		return strconv.Quote(c.LogLevel)
+	case "log_format":
+		return strconv.Quote(c.LogFormat)
+	case "history_days":
+		return strconv.Itoa(c.HistoryDays)
+	case "max_charge_temp":
+		return strconv.Itoa(c.MaxChargeTemp)
+	case "pause_when_hot":
+		return strconv.FormatBool(c.PauseWhenHot)
+	case "saver_level":
+		return strconv.Itoa(c.Saver.Level)
+	case "saver_on":
+		return strconv.Quote(c.Saver.On)
+	case "saver_off":
+		return strconv.Quote(c.Saver.Off)
+	case "vacation_threshold":
+		return strconv.Itoa(c.VacationThreshold)
+	case "time_format":
+		return strconv.Quote(c.TimeFormat)
+	case "clock":
+		return strconv.Quote(c.Clock)
+	}
+	return ""
+}
+
+// configFiles returns the configuration files in the order they apply, so
+// that keys in later files take precedence: the system-wide defaults, then
+// those of the user under XDG_CONFIG_HOME, or only the file given with
+// --config.
+func configFiles() []string {
+	if configFile != "" {
+		return []string{configFile}
+	}
+	files := []string{defaultConfig}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return files
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return append(files, filepath.Join(dir, "bat", "config.toml"))
+}
+
+// findConfig merges the configuration files that exist and returns the
+// result along with the file that set each key. Keys that no file sets
+// are left out of the map and hold their defaults. It returns an error
+// wrapping fs.ErrNotExist if no file exists, or the file given with
+// --config does not.
+func findConfig() (Config, map[string]string, error) {
+	c := newConfig()
+	sources := make(map[string]string)
+	found := false
+	files := configFiles()
+	for _, path := range files {
+		keys, err := c.read(path)
+		if errors.Is(err, fs.ErrNotExist) && configFile == "" {
+			continue
+		}
+		if err != nil {
+			return c, sources, err
+		}
+		found = true
+		for _, key := range keys {
+			sources[key] = path
+		}
+	}
+	if !found {
+		return c, sources, fmt.Errorf("%s: %w", strings.Join(files, ", "), fs.ErrNotExist)
+	}
+	return c, sources, nil
+}
+
+// validate checks the keys against each other and against the values the
+// commands accept, beyond what read checks for each key on its own. It
+// returns the problems found as pairs of keys and descriptions.
+func (c Config) validate(batteries []*Device) [][2]string {
+	problems := make([][2]string, 0)
+	if c.Device != "" {
+		if _, ok := device(batteries, c.Device); !ok {
+			problems = append(problems, [2]string{"device", "there is no battery named " + c.Device})
+		}
+	}
+	if c.Start >= 0 && c.Threshold != 0 && c.Start >= c.Threshold {
+		problems = append(problems, [2]string{"start", "should be below the threshold"})
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		problems = append(problems, [2]string{"log_level", "should be one of debug, info, warn or error"})
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		problems = append(problems, [2]string{"log_format", "should be one of text or json"})
+	}
+	if c.PauseWhenHot && c.MaxChargeTemp == 0 {
+		problems = append(problems, [2]string{"pause_when_hot", "has no effect without max_charge_temp"})
+	}
+	return problems
+}
+
+// cmdApply applies the configuration file without asking, for provisioning.
+func cmdApply(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	flags.Usage = flag.Usage
+	fromConfig := flags.Bool("from-config", false, ignore)
+	flags.Parse(args)
+	if !*fromConfig || flags.NArg() > 1 {
+		return errUsage
+	}
+	// Nothing is printed on success since this is meant to be run from
+	// package scripts and configuration management tools, which only
+	// consider the exit status.
+	var (
+		c   Config
+		err error
+	)
+	if flags.NArg() == 1 {
+		c, err = loadConfig(flags.Arg(0))
+	} else {
+		c, _, err = findConfig()
+	}
+	// The file may be left out if the environment provides the
+	// threshold.
+	if errors.Is(err, fs.ErrNotExist) && os.Getenv("BAT_THRESHOLD") != "" {
+		err = nil
+	}
+	if err == nil {
+		err = c.override(nil)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errConfig) {
+			return userError(fmt.Sprintf("%v.", err))
+		}
+		return err
+	}
+	if c.Threshold == 0 {
+		return userError("The configuration does not set a threshold.")
+	}
+	d := bat
+	if c.Device != "" {
+		var ok bool
+		if d, ok = device(batteries, c.Device); !ok {
+			return userError(fmt.Sprintf("There is no `%s` battery.", c.Device))
+		}
+	}
+	if d.Capabilities()&HasThreshold == 0 {
+		return userError(missing(d))
+	}
+	if q, ok := machineQuirk(); ok && !slices.Contains(q.Values, c.Threshold) {
+		return userError(q.message(c.Threshold))
+	}
+	if c.Start == -1 {
+		err = d.set(threshold, c.Threshold)
+	} else {
+		if d.Capabilities()&HasStartThreshold == 0 {
+			return userError(unsupported(d, HasStartThreshold))
+		}
+		err = d.setThresholds(c.Start, c.Threshold)
+		if errors.Is(err, errThresholdOrder) {
+			return userError("The start threshold should be below the end threshold.")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if c.Persist {
+		_, err := persist(ctx, defaultUnits, batteries)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cmdConfig prints, checks or changes the configuration.
+func cmdConfig(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	effective := flags.Bool("effective", false, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args[1:])
+	switch {
+	case args[0] == "show" && flags.NArg() == 0:
+		if !*effective {
+			for _, path := range configFiles() {
+				if _, err := os.Stat(path); err != nil {
+					path += " (not found)"
+				}
+				fmt.Println(path)
+			}
+			return nil
+		}
+		c, sources := mustConfig(ctx)
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, key := range configKeys {
+			source, ok := sources[key]
+			if !ok {
+				source = "default"
+			}
+			fmt.Fprintf(tw, "%s = %s\t# %s\n", key, c.value(key), source)
+		}
+		tw.Flush()
+	case args[0] == "validate" && flags.NArg() <= 1 && !*effective:
+		if flags.NArg() == 1 {
+			configFile = flags.Arg(0)
+		}
+		c, sources, err := findConfig()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errConfig) {
+				return userError(fmt.Sprintf("%v.", err))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		problems := c.validate(batteries)
+		for _, p := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s: %s.\n", sources[p[0]], p[0], p[1])
+		}
+		if len(problems) > 0 {
+			return errFailed
+		}
+		fmt.Println("The configuration is valid.")
+	default:
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		got      func(c Config) any
+		want     any
+		wantErr  error
+	}{
+		{
+			name:     "comment",
+			contents: "threshold = 80 # the recommended one\n",
+			got:      func(c Config) any { return c.Threshold },
+			want:     80,
+		},
+		{
+			name:     "hash in string",
+			contents: "saver_on = \"notify-send '#battery'\"\n",
+			got:      func(c Config) any { return c.Saver.On },
+			want:     "notify-send '#battery'",
+		},
+		{
+			name:     "hash in string and comment",
+			contents: "saver_on = \"echo #1\" # numbered\n",
+			got:      func(c Config) any { return c.Saver.On },
+			want:     "echo #1",
+		},
+		{
+			name:     "escaped quote",
+			contents: "saver_off = \"echo \\\"#2\\\"\" # quoted\n",
+			got:      func(c Config) any { return c.Saver.Off },
+			want:     `echo "#2"`,
+		},
+		{
+			name:     "unterminated string",
+			contents: "saver_on = \"echo # oops\n",
+			wantErr:  errConfig,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			c := newConfig()
+			_, err := c.read(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("read = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := tt.got(c); got != tt.want {
+				t.Errorf("read = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// identifying are the DMI variables included in crash reports. Serial
+// numbers and UUIDs are left out.
+var identifying = [...]string{"sys_vendor", "product_name", "product_version", "bios_version", "bios_date"}
+
+// redacted reports whether the contents of a power_supply variable, or a
+// line of its uevent file, identify the battery and are to be left out of
+// crash reports.
+func redacted(variable string) bool {
+	return strings.Contains(strings.ToLower(variable), "serial")
+}
+
+// crashReport writes everything needed to act on a crash to w: the error and
+// stack, the command line, the build, the kernel, the machine, and the
+// variables of every power supply with serial numbers redacted.
+func crashReport(w io.Writer, recovered any, stack []byte, now time.Time) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Command: %s\n", strings.Join(os.Args, " "))
+	bi := build()
+	fmt.Fprintf(&b, "Version: %s %s %s %s\n", bi.Version, bi.Commit, bi.GoVersion, bi.Platform)
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err == nil {
+		fmt.Fprintf(&b, "Kernel: %s %s\n", unix.ByteSliceToString(uname.Release[:]), unix.ByteSliceToString(uname.Version[:]))
+	}
+	for _, variable := range identifying {
+		if v, err := readString(filepath.Join(dmi, variable)); err == nil {
+			fmt.Fprintf(&b, "DMI %s: %s\n", variable, v)
+		}
+	}
+	fmt.Fprintf(&b, "\nError: %v\n\n%s\n", recovered, stack)
+
+	// The snapshot is taken directly since the crash may lie in probing
+	// the devices.
+	roots, err := sysfs.Glob(filepath.Join(supplies, "*"))
+	if err != nil {
+		fmt.Fprintf(&b, "%s: %v\n", supplies, err)
+	}
+	for _, root := range roots {
+		fmt.Fprintf(&b, "%s:\n", root)
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			fmt.Fprintf(&b, "\t%v\n", err)
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			if redacted(name) {
+				fmt.Fprintf(&b, "\t%s: [redacted]\n", name)
+				continue
+			}
+			contents, err := sysfs.ReadFile(filepath.Join(root, name))
+			if err != nil {
+				fmt.Fprintf(&b, "\t%s: %v\n", name, err)
+				continue
+			}
+			for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+				if key, _, ok := strings.Cut(line, "="); ok && redacted(key) {
+					line = key + "=[redacted]"
+				}
+				fmt.Fprintf(&b, "\t%s: %s\n", name, line)
+			}
+		}
+	}
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+// writeCrashReport writes a crash report to a new file in the temporary
+// directory and returns its path.
+func writeCrashReport(recovered any, stack []byte) (string, error) {
+	f, err := os.CreateTemp("", "bat-crash-*.txt")
+	if err != nil {
+		return "", err
+	}
+	if err := crashReport(f, recovered, stack, time.Now()); err != nil {
+		f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// criticalActions are the actions the daemon may take when the batteries
+// discharge below the level given with --inhibit-sleep-below.
+var criticalActions = [...]string{"warn", "suspend", "hibernate"}
+
+// inhibited are the logind handlers blocked while the batteries are
+// critically low and the action is warn, so that closing the lid or
+// pressing the suspend key does not put the machine into a suspend it may
+// not wake up from. The other actions suspend the machine themselves so
+// they take no inhibitor.
+const inhibited = "handle-lid-switch:handle-suspend-key"
+
+// critical reports whether the batteries are discharging below the level:
+// every battery present is below it and at least one is discharging.
+func critical(states []State, level int) bool {
+	discharging := false
+	for _, s := range states {
+		if s.Status == removed {
+			continue
+		}
+		if s.Capacity >= level {
+			return false
+		}
+		discharging = discharging || s.Status == "Discharging"
+	}
+	return discharging
+}
+
+// guard takes the critical action once the batteries discharge below
+// d.critical: warn logs a warning and takes a logind inhibitor, released
+// once they no longer are, while suspend and hibernate put the machine to
+// sleep. It stands in for a desktop power manager on machines that run
+// none. Failures are logged rather than stopping the daemon.
+func (d *daemon) guard(ctx context.Context, states []State) {
+	if d.critical == 0 {
+		return
+	}
+	if !critical(states, d.critical) {
+		if d.inhibitor != nil {
+			d.inhibitor.Process.Kill()
+			d.inhibitor.Wait()
+			d.inhibitor = nil
+			d.log.Info("released inhibitor", "operation", "critical", "value", d.critical)
+		}
+		d.low = false
+		return
+	}
+	if d.low {
+		return
+	}
+	d.low = true
+	d.log.Warn("battery critically low", "operation", "critical", "value", d.critical, "action", d.action)
+	if d.action == "suspend" || d.action == "hibernate" {
+		if _, err := systemctl(ctx, d.action); err != nil {
+			d.log.Error("critical action failed", "operation", "critical", "value", d.action, "error", err)
+		}
+		return
+	}
+	why := fmt.Sprintf("Battery below %d%%", d.critical)
+	cmd := exec.CommandContext(ctx, "systemd-inhibit", "--what="+inhibited, "--who=bat", "--why="+why, "--mode=block", "sleep", "infinity")
+	if err := cmd.Start(); err != nil {
+		d.log.Error("taking inhibitor failed", "operation", "critical", "value", d.critical, "error", err)
+		return
+	}
+	d.inhibitor = cmd
+}
@@ -0,0 +1,753 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// State is a snapshot of a battery as reported to clients of the daemon.
+type State struct {
+	Battery   string `json:"battery"`
+	Capacity  int    `json:"capacity"`
+	Status    string `json:"status"`
+	Threshold int    `json:"threshold,omitempty"`
+}
+
+// Request is a JSON-RPC 2.0 request. Requests and responses are delimited
+// by newlines.
+type Request struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response, or a notification if ID is unset.
+type Response struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is a JSON-RPC 2.0 error object.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes defined by the JSON-RPC 2.0 specification, and one for
+// clients that are not allowed to change settings.
+const (
+	rpcParseError     = -32700
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcUnauthorised   = -32000
+)
+
+// SetThresholdParams are the parameters of the set_threshold method.
+type SetThresholdParams struct {
+	Battery   string `json:"battery"`
+	Threshold int    `json:"threshold"`
+}
+
+// daemonSocket is the default path of the control socket.
+var daemonSocket = filepath.Join("/", "run", "bat", "bat.sock")
+
+// daemon monitors the batteries and serves their state over a unix socket
+// so that applets do not need to spawn the CLI repeatedly.
+type daemon struct {
+	batteries []*Device
+	// group may change settings in addition to the superuser, or -1.
+	group int
+	// interval is the polling interval on AC power and idle the one on
+	// battery power.
+	interval, idle time.Duration
+	// policies are applied whenever power is supplied by a source of the
+	// given class, and baseline holds the thresholds restored for sources
+	// without one.
+	policies map[string]Policy
+	baseline map[string]int
+	source   string
+	// enforced is whether thresholds changed by other programs are
+	// reverted to those in pinned.
+	enforced bool
+	// maxTemp is the temperature in degrees Celsius above which charging
+	// raises a warning, or zero, and pauseHot whether charging is also
+	// paused, for the batteries in hot.
+	maxTemp  int
+	pauseHot bool
+	hot      map[string]bool
+	// saver switches the power profile on low battery, and saving and
+	// prior track whether it is active and the profile to restore.
+	saver  Saver
+	saving bool
+	prior  string
+	// exec is a shell command run on the events of the batteries, with
+	// levels the capacities whose crossing is one.
+	exec   string
+	levels []int
+	// hooks queues the events for the command, which is run apart from
+	// the poll loop.
+	hooks chan []Event
+	// signals is whether the events are also emitted as D-Bus signals.
+	signals bool
+	// critical is the level below which the action is taken while
+	// discharging, or zero, and low whether it has been. inhibitor is held
+	// meanwhile when the action is warn.
+	critical  int
+	action    string
+	low       bool
+	inhibitor *exec.Cmd
+	log       *slog.Logger
+
+	mu          sync.Mutex
+	last        []State
+	subscribers map[chan []State]struct{}
+	pinned      map[string]int
+	// read is when the batteries were last read, and busy when the
+	// current reading started, or zero while waiting for the next.
+	read, busy time.Time
+}
+
+// removed is the status reported for a battery that has been taken out.
+const removed = "Removed"
+
+// states reads the current state of every battery. Each battery is read
+// from its uevent file in one go, falling back to the individual variables
+// for those missing from it.
+func (d *daemon) states() ([]State, error) {
+	batteries := d.devices()
+	states := make([]State, 0, len(batteries))
+	for _, b := range batteries {
+		if !b.present() {
+			states = append(states, State{Battery: b.Name, Status: removed})
+			continue
+		}
+		variables, err := b.uevent()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		get := func(variable string) (string, error) {
+			if v, ok := variables[variable]; ok {
+				return v, nil
+			}
+			return b.read(variable)
+		}
+
+		s := State{Battery: b.Name}
+		v, err := get("capacity")
+		if err != nil {
+			// The battery may be taken out between the checks.
+			if errors.Is(err, fs.ErrNotExist) {
+				states = append(states, State{Battery: b.Name, Status: removed})
+				continue
+			}
+			return nil, err
+		}
+		if s.Capacity, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+		if s.Status, err = get("status"); err != nil {
+			return nil, err
+		}
+		if b.Capabilities()&HasThreshold != 0 {
+			v, err := get(threshold)
+			if err != nil {
+				return nil, err
+			}
+			if s.Threshold, err = strconv.Atoi(v); err != nil {
+				return nil, err
+			}
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// devices returns the batteries currently known to the daemon.
+func (d *daemon) devices() []*Device {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.batteries
+}
+
+// rescan updates the batteries known to the daemon, which change at
+// runtime on machines with removable batteries. Capabilities are probed
+// again since a battery inserted after startup exposes variables it did
+// not before, and batteries whose directory has gone are kept so that
+// they are reported as removed.
+func (d *daemon) rescan() error {
+	batteries, err := devices()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	merged := make([]*Device, 0, len(d.batteries))
+	for _, b := range d.batteries {
+		if fresh, ok := device(batteries, b.Name); ok {
+			b = fresh
+		}
+		merged = append(merged, b)
+	}
+	for _, b := range batteries {
+		if _, ok := device(merged, b.Name); !ok {
+			d.log.Info("battery added", "device", b.Name, "operation", "rescan")
+			merged = append(merged, b)
+		}
+	}
+	d.batteries = merged
+	return nil
+}
+
+// debounce is how long to wait for further uevents before reading the
+// batteries, since drivers often emit several at once.
+const debounce = 250 * time.Millisecond
+
+// poll publishes the state to subscribers whenever it changes until ctx
+// is cancelled. It reads the batteries on uevents, falling back to
+// polling at the interval, or the longer idle interval while running on
+// battery so that the daemon does not itself drain it.
+func (d *daemon) poll(ctx context.Context) error {
+	events, err := uevents(ctx)
+	if err != nil {
+		// Netlink may be unavailable in containers.
+		d.log.Warn("falling back to polling", "operation", "uevents", "error", err)
+		events = nil
+	}
+	defer d.resume()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(debounce):
+			}
+			// Drain events received while waiting.
+			select {
+			case <-events:
+			default:
+			}
+		}
+
+		start := time.Now()
+		d.mu.Lock()
+		d.busy = start
+		d.mu.Unlock()
+		if err := d.rescan(); err != nil {
+			return err
+		}
+		states, err := d.states()
+		if err != nil {
+			d.log.Error("reading batteries failed", "operation", "read", "error", err)
+			return err
+		}
+		d.log.Debug("read batteries", "operation", "read", "duration", time.Since(start))
+		d.mu.Lock()
+		previous := d.last
+		if !slices.Equal(states, d.last) {
+			for _, s := range states {
+				if slices.Contains(d.last, s) {
+					continue
+				}
+				if s.Status == removed {
+					d.log.Info("battery removed", "device", s.Battery, "operation", "read")
+					continue
+				}
+				d.log.Info("state changed", "device", s.Battery, "operation", "read",
+					"value", s.Capacity, "status", s.Status, "threshold", s.Threshold)
+			}
+			d.last = states
+			for ch := range d.subscribers {
+				// Drop updates for slow subscribers rather than blocking
+				// the others.
+				select {
+				case ch <- states:
+				default:
+				}
+			}
+		}
+		d.mu.Unlock()
+		events := transitions(previous, states, d.levels)
+		if d.exec != "" {
+			d.trigger(events)
+		}
+		if d.signals {
+			d.signal(ctx, events)
+		}
+		d.guard(ctx, states)
+
+		source := d.source
+		d.enforce()
+		// States read before a policy was applied are stale.
+		if d.enforced && d.source == source {
+			d.revert(states)
+		}
+
+		if err := d.cool(states); err != nil {
+			return err
+		}
+		d.save(ctx, states)
+
+		d.mu.Lock()
+		ready := d.read.IsZero()
+		d.read, d.busy = time.Now(), time.Time{}
+		d.mu.Unlock()
+		// The service manager waits for the first reading before starting
+		// the units ordered after the daemon.
+		if ready {
+			if err := notify("READY=1"); err != nil {
+				d.log.Warn("notifying service manager failed", "operation", "notify", "error", err)
+			}
+		}
+
+		interval := d.interval
+		if discharging(states) {
+			interval = d.idle
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+// enforce applies the policy for the class of source supplying power when
+// it changes. Sources without a policy restore the thresholds the daemon
+// started with, and running on battery leaves the settings alone unless
+// there is a policy for battery. Failures are logged and the policy is
+// applied again on the next reading, since they are often transient.
+func (d *daemon) enforce() {
+	if len(d.policies) == 0 {
+		return
+	}
+	srcs, err := sources()
+	if err != nil {
+		d.log.Error("reading power sources failed", "operation", "policy", "error", err)
+		return
+	}
+	class := online(srcs)
+	if class == "" {
+		if _, ok := d.policies["battery"]; !ok {
+			return
+		}
+		class = "battery"
+	}
+	if class == d.source {
+		return
+	}
+	if d.baseline == nil {
+		d.baseline = make(map[string]int)
+		for _, s := range d.last {
+			d.baseline[s.Battery] = s.Threshold
+		}
+	}
+	start := time.Now()
+	if policy, ok := d.policies[class]; ok {
+		err = policy.apply(d.devices())
+	} else {
+		for _, b := range d.devices() {
+			if err = (Policy{Threshold: d.baseline[b.Name]}).apply([]*Device{b}); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		d.log.Error("applying source policy failed", "operation", "policy", "source", class,
+			"duration", time.Since(start), "error", err)
+		return
+	}
+	d.log.Info("applied source policy", "operation", "policy", "source", class,
+		"duration", time.Since(start))
+	d.source = class
+	// The thresholds are pinned again once the policy has been read back.
+	d.mu.Lock()
+	d.pinned = nil
+	d.mu.Unlock()
+}
+
+// discharging reports whether every battery is discharging, which means
+// the system is running on battery.
+func discharging(states []State) bool {
+	for _, s := range states {
+		if s.Status != "Discharging" {
+			return false
+		}
+	}
+	return len(states) > 0
+}
+
+// run polls the batteries and serves clients until ctx is cancelled or
+// either fails.
+func (d *daemon) run(ctx context.Context, l net.Listener) error {
+	d.log.Info("listening", "socket", l.Addr().String(), "interval", d.interval,
+		"battery_interval", d.idle)
+	ctx, cancel := context.WithCancelCause(ctx)
+	if d.exec != "" {
+		d.hooks = make(chan []Event, hookQueue)
+		go d.runHooks(ctx)
+	}
+	go func() { cancel(d.poll(ctx)) }()
+	if interval := watchdog(); interval > 0 {
+		go d.supervise(ctx, interval)
+	}
+	defer notify("STOPPING=1")
+	if err := d.serve(ctx, l); err != nil {
+		return err
+	}
+	if cause := context.Cause(ctx); !errors.Is(cause, context.Canceled) {
+		return cause
+	}
+	return nil
+}
+
+// serve accepts connections until ctx is cancelled.
+func (d *daemon) serve(ctx context.Context, l net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.handle(ctx, conn.(*net.UnixConn))
+		}()
+	}
+}
+
+// handle serves the requests of a single client.
+func (d *daemon) handle(ctx context.Context, conn *net.UnixConn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// Responses and notifications are written from different goroutines.
+	var mu sync.Mutex
+	enc := json.NewEncoder(conn)
+	send := func(r Response) {
+		mu.Lock()
+		defer mu.Unlock()
+		r.Version = "2.0"
+		enc.Encode(r)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			send(Response{Error: &RPCError{rpcParseError, err.Error()}})
+			continue
+		}
+		r := Response{ID: req.ID}
+		switch req.Method {
+		case "state":
+			states, err := d.states()
+			if err != nil {
+				r.Error = &RPCError{rpcInternalError, err.Error()}
+				break
+			}
+			r.Result = states
+		case "set_threshold":
+			var p SetThresholdParams
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				r.Error = &RPCError{rpcInvalidParams, err.Error()}
+				break
+			}
+			if !d.authorised(conn) {
+				d.log.Warn("unauthorised client", "device", p.Battery, "operation", "set_threshold",
+					"value", p.Threshold)
+				r.Error = &RPCError{rpcUnauthorised, "permission denied"}
+				break
+			}
+			b, ok := device(d.devices(), p.Battery)
+			if !ok || !b.present() || b.Capabilities()&HasThreshold == 0 || p.Threshold < 1 || p.Threshold > 100 {
+				r.Error = &RPCError{rpcInvalidParams, "invalid battery or threshold"}
+				break
+			}
+			if q, ok := machineQuirk(); ok && !slices.Contains(q.Values, p.Threshold) {
+				r.Error = &RPCError{rpcInvalidParams, q.message(p.Threshold)}
+				break
+			}
+			start := time.Now()
+			if err := b.set(threshold, p.Threshold); err != nil {
+				d.log.Error("setting threshold failed", "device", b.Name, "operation", "set_threshold",
+					"value", p.Threshold, "duration", time.Since(start), "error", err)
+				r.Error = &RPCError{rpcInternalError, err.Error()}
+				break
+			}
+			d.log.Info("set threshold", "device", b.Name, "operation", "set_threshold",
+				"value", p.Threshold, "duration", time.Since(start))
+			if cred, err := peer(conn); err == nil {
+				if err := recordChange(b.Name, int(cred.Uid), p.Threshold, time.Now()); err != nil {
+					d.log.Warn("recording change failed", "device", b.Name, "operation", "set_threshold", "error", err)
+				}
+			}
+			d.pin(b.Name, p.Threshold)
+			r.Result = true
+		case "health":
+			r.Result = d.health(time.Now())
+		case "subscribe":
+			ch := make(chan []State, 1)
+			d.mu.Lock()
+			if d.subscribers == nil {
+				d.subscribers = make(map[chan []State]struct{})
+			}
+			d.subscribers[ch] = struct{}{}
+			d.mu.Unlock()
+			go func() {
+				defer func() {
+					d.mu.Lock()
+					delete(d.subscribers, ch)
+					d.mu.Unlock()
+				}()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case states := <-ch:
+						send(Response{Method: "changed", Params: states})
+					}
+				}
+			}()
+			r.Result = true
+		default:
+			r.Error = &RPCError{rpcMethodNotFound, "method not found"}
+		}
+		// Notifications from the client do not get a response.
+		if req.ID != nil {
+			send(r)
+		}
+	}
+}
+
+// peer returns the credentials of the process at the other end of conn.
+func peer(conn *net.UnixConn) (*unix.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *unix.Ucred
+	if cerr := raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); cerr != nil {
+		return nil, cerr
+	}
+	return cred, err
+}
+
+// authorised reports whether the peer may change settings: the superuser
+// or, if configured, members of the daemon's group.
+func (d *daemon) authorised(conn *net.UnixConn) bool {
+	cred, err := peer(conn)
+	if err != nil || cred == nil {
+		return false
+	}
+	if cred.Uid == 0 {
+		return true
+	}
+	if d.group < 0 {
+		return false
+	}
+	groups, err := groupsOf(int(cred.Pid))
+	return err == nil && slices.Contains(groups, d.group)
+}
+
+// groupsOf returns the primary and supplementary groups of a process.
+func groupsOf(pid int) ([]int, error) {
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "status"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	groups := make([]int, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), ":")
+		if key != "Gid" && key != "Groups" {
+			continue
+		}
+		fields := strings.Fields(value)
+		if key == "Gid" && len(fields) > 0 {
+			// Real, effective, saved and filesystem; the effective one
+			// matters.
+			fields = fields[1:2]
+		}
+		for _, field := range fields {
+			gid, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, gid)
+		}
+	}
+	return groups, scanner.Err()
+}
+
+// listen creates the control socket, replacing a stale one.
+func listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Reading the state is harmless so the socket is open to all users;
+	// changing settings is checked per request.
+	if err := os.Chmod(path, 0o666); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// cmdDaemon monitors the batteries and serves their state until interrupted.
+func cmdDaemon(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	path := flags.String("socket", daemonSocket, ignore)
+	interval := flags.Duration("interval", 5*time.Second, ignore)
+	idle := flags.Duration("battery-interval", time.Minute, ignore)
+	group := flags.String("group", "", ignore)
+	policy := flags.String("source-policy", "", ignore)
+	level := flags.String("log-level", "", ignore)
+	format := flags.String("log-format", "", ignore)
+	enforce := flags.Bool("enforce", false, ignore)
+	hook := flags.String("exec", "", ignore)
+	at := flags.String("exec-at", "", ignore)
+	signals := flags.Bool("dbus-signal", false, ignore)
+	below := flags.Int("inhibit-sleep-below", 0, ignore)
+	action := flags.String("critical-action", "warn", ignore)
+	replace := flags.Bool("replace", false, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if *interval <= 0 || *idle <= 0 {
+		return userError("The intervals should be positive.")
+	}
+	if *below < 0 || *below > 100 || !slices.Contains(criticalActions[:], *action) {
+		return userError("The critical level should be between 1 and 100 and the action one of\n" +
+			"warn, suspend or hibernate.")
+	}
+	levels, err := parseLevels(*at)
+	if err != nil {
+		return userError("Levels should be of the form `20,80`, between 1 and 100.")
+	}
+	// Flags take precedence over the configuration file, which is
+	// optional.
+	c, _ := mustConfig(ctx)
+	if *level == "" {
+		*level = c.LogLevel
+	}
+	if *format == "" {
+		*format = c.LogFormat
+	}
+	logger, err := newLogger(os.Stderr, *level, *format)
+	if err != nil {
+		return userError("The log level should be one of debug, info, warn or error and the\n" +
+			"format one of text or json.")
+	}
+	policies, err := parsePolicies(*policy)
+	if err != nil {
+		return userError("Source policies should be of the form `ac=80,usb-pd=60,usb=inhibit`.")
+	}
+
+	gid := -1
+	if *group != "" {
+		g, err := user.LookupGroup(*group)
+		if err != nil {
+			return userError(fmt.Sprintf("There is no `%s` group.", *group))
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			panic(err)
+		}
+	}
+	lock, pid, err := instance(ctx, pidFile(*path), *replace)
+	switch {
+	case errors.Is(err, errRunning):
+		return userError(fmt.Sprintf("The daemon is already running (pid %d). Use --replace to take over\n"+
+			"from it.", pid))
+	case errors.Is(err, errNotStopped):
+		return userError(fmt.Sprintf("The running daemon (pid %d) did not stop within %s.", pid, takeover))
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	l, err := listen(*path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(*path)
+	d := &daemon{
+		batteries: batteries,
+		group:     gid,
+		interval:  *interval,
+		idle:      *idle,
+		policies:  policies,
+		enforced:  *enforce,
+		maxTemp:   c.MaxChargeTemp,
+		pauseHot:  c.PauseWhenHot,
+		saver:     c.Saver,
+		exec:      *hook,
+		levels:    levels,
+		signals:   *signals,
+		critical:  *below,
+		action:    *action,
+		log:       logger,
+	}
+	if err := d.run(ctx, l); err != nil {
+		panic(err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// The object and interface on the system bus from which the daemon emits
+// signals with --dbus-signal.
+const (
+	dbusPath      = "/dev/tshaka/bat"
+	dbusInterface = "dev.tshaka.bat1"
+)
+
+// Signal is a D-Bus signal emitted for an event, with Signature the D-Bus
+// type of each of its arguments.
+type Signal struct {
+	Member    string
+	Signature string
+	Args      []string
+}
+
+// signalOf returns the signal for the event, if any:
+//
+//   - ThresholdChanged (device, threshold, previous) when the charging
+//     threshold changes.
+//   - CapacityLow (device, capacity) when the capacity falls below one of
+//     the levels given with --exec-at.
+//   - ChargeLimited (device, capacity, threshold) when charging stops
+//     because the battery has reached its threshold.
+func signalOf(e Event) (Signal, bool) {
+	s := e.State
+	capacity, limit := strconv.Itoa(s.Capacity), strconv.Itoa(s.Threshold)
+	switch e.Kind {
+	case "threshold":
+		return Signal{"ThresholdChanged", "suu", []string{s.Battery, limit, e.Previous}}, true
+	case "level":
+		if previous, err := strconv.Atoi(e.Previous); err == nil && s.Capacity < previous {
+			return Signal{"CapacityLow", "su", []string{s.Battery, capacity}}, true
+		}
+	case "status":
+		// As in explain, firmware stops a few percent short of the limit on
+		// some models.
+		held := s.Status == "Not charging" || s.Status == "Unknown"
+		if held && s.Threshold > 0 && s.Threshold < 100 && s.Capacity >= s.Threshold-tolerance {
+			return Signal{"ChargeLimited", "suu", []string{s.Battery, capacity, limit}}, true
+		}
+	}
+	return Signal{}, false
+}
+
+// signal emits the signals for the events on the system bus with busctl.
+// Failures are logged rather than stopping the daemon.
+func (d *daemon) signal(ctx context.Context, events []Event) {
+	for _, e := range events {
+		s, ok := signalOf(e)
+		if !ok {
+			continue
+		}
+		args := append([]string{"--system", "emit", dbusPath, dbusInterface, s.Member, s.Signature}, s.Args...)
+		output, err := runner.Run(exec.CommandContext(ctx, "busctl", args...))
+		if err != nil {
+			d.log.Error("emitting signal failed", "device", e.State.Battery, "operation", "signal",
+				"value", s.Member, "error", fmt.Errorf("%s: %w", bytes.TrimSpace(output), err))
+			continue
+		}
+		d.log.Debug("emitted signal", "device", e.State.Battery, "operation", "signal", "value", s.Member)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// sysman holds the BIOS settings exposed by dell-wmi-sysman. Dell laptops
+// whose kernel lacks the charge control variables of dell_laptop take the
+// thresholds from the Custom charge mode settings here instead.
+var sysman = filepath.Join("/", "sys", "class", "firmware-attributes", "dell-wmi-sysman", "attributes")
+
+// dellSettings maps the threshold variables to the BIOS settings holding
+// them.
+var dellSettings = map[string]string{
+	threshold:      "CustomChargeStop",
+	startThreshold: "CustomChargeStart",
+}
+
+// dellChargeMode is the BIOS setting selecting the charge mode, which has
+// to be Custom for the thresholds to apply.
+const dellChargeMode = "PrimaryBattChargeCfg"
+
+// dellPath returns the file holding the variable in the BIOS settings, or
+// false if it is not one of them.
+func dellPath(variable string) (string, bool) {
+	setting, ok := dellSettings[variable]
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(sysman, setting, "current_value"), true
+}
+
+// isDell reports whether path is a BIOS setting.
+func isDell(path string) bool {
+	return strings.HasPrefix(path, sysman+string(filepath.Separator))
+}
+
+// customCharge switches the BIOS to the Custom charge mode, if it is not
+// already, so that the thresholds written take effect.
+func customCharge() error {
+	path := filepath.Join(sysman, dellChargeMode, "current_value")
+	mode, err := readString(path)
+	if err != nil {
+		return err
+	}
+	if mode == "Custom" {
+		return nil
+	}
+	return sysfs.WriteFile(path, []byte("Custom"))
+}
+
+// dellLimit returns the charge level the BIOS stops charging at and the
+// setting responsible, whether or not the threshold is set through it.
+// The Standard and Express charge modes charge fully while the Adaptive
+// and primarily AC modes leave the level to the firmware, in which case
+// false is returned.
+func dellLimit() (int, string, bool, error) {
+	mode, err := readString(filepath.Join(sysman, dellChargeMode, "current_value"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return 0, "", false, err
+	}
+	switch mode {
+	case "Standard", "Express":
+		return 100, fmt.Sprintf("%s = %s", dellChargeMode, mode), true, nil
+	case "Custom":
+	default:
+		return 0, "", false, nil
+	}
+	v, err := readInt(filepath.Join(sysman, dellSettings[threshold], "current_value"))
+	if err != nil {
+		return 0, "", false, err
+	}
+	return v, fmt.Sprintf("%s = %d", dellSettings[threshold], v), true, nil
+}
+
+// setDellLimit switches the BIOS to the Custom charge mode and stops
+// charging at v, for when the threshold is set elsewhere.
+func setDellLimit(v int) error {
+	if err := customCharge(); err != nil {
+		return err
+	}
+	path := filepath.Join(sysman, dellSettings[threshold], "current_value")
+	return writeInt(path, v)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Capability is a set of optional features of a battery, as determined by
+// the variables its driver exposes.
+type Capability uint
+
+const (
+	HasThreshold Capability = 1 << iota
+	HasStartThreshold
+	HasHealth
+	HasChargeBehaviour
+	HasAlarm
+	HasPowerReadings
+	HasVoltage
+	HasTemperature
+)
+
+// features names each capability and lists the variables of which at
+// least one set must be present for it to be supported.
+var features = [...]struct {
+	capability Capability
+	name       string
+	variables  [][]string
+}{
+	{HasThreshold, "charging threshold", [][]string{{threshold}}},
+	{HasStartThreshold, "start threshold", [][]string{{startThreshold}}},
+	{HasHealth, "health", [][]string{{"charge_full", "charge_full_design"}, {"energy_full", "energy_full_design"}}},
+	{HasChargeBehaviour, "charge behaviour", [][]string{{"charge_behaviour"}}},
+	{HasAlarm, "alarm", [][]string{{"alarm"}}},
+	{HasPowerReadings, "power readings", [][]string{{"power_now"}, {"current_now", "voltage_now"}}},
+	{HasVoltage, "voltage", [][]string{{"voltage_now"}}},
+	{HasTemperature, "temperature", [][]string{{"temp"}}},
+}
+
+// String lists the names of the capabilities in c.
+func (c Capability) String() string {
+	names := make([]string, 0, len(features))
+	for _, f := range features {
+		if c&f.capability != 0 {
+			names = append(names, f.name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "nothing"
+	case 1:
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// Device is a battery and the capabilities of its driver.
+type Device struct {
+	battery
+	Name         string
+	capabilities Capability
+}
+
+// Capabilities returns the features supported by the device.
+func (d *Device) Capabilities() Capability {
+	return d.capabilities
+}
+
+// present reports whether the battery is in the machine. Removable
+// batteries either keep their directory with present set to 0 or
+// disappear altogether, depending on the driver.
+func (b *battery) present() bool {
+	v, err := b.read("present")
+	if errors.Is(err, fs.ErrNotExist) {
+		_, err := sysfs.Stat(b.root)
+		return err == nil
+	}
+	return err != nil || v != "0"
+}
+
+// names matches the batteries of supplies that do not report a type.
+// Most are called BAT0 or BAT1 but BATT, BATC and CMB1 are also in use.
+var names = [...]string{"BAT*", "CMB*"}
+
+// isSystem reports whether the supply is a battery powering the machine
+// rather than a peripheral, such as a mouse, which reports a device scope.
+func (b *battery) isSystem() (bool, error) {
+	kind, err := b.read("type")
+	if errors.Is(err, fs.ErrNotExist) {
+		name := filepath.Base(b.root)
+		for _, pattern := range names {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if err != nil || kind != "Battery" {
+		return false, err
+	}
+	scope, err := b.read("scope")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return scope != "Device", nil
+}
+
+// devices returns the batteries on the system with their capabilities
+// probed. The legacy ACPI interface is only used if there are none.
+func devices() ([]*Device, error) {
+	devs, err := probe(filepath.Join(supplies, "*"))
+	if err != nil || len(devs) > 0 {
+		return devs, err
+	}
+	return probe(filepath.Join(acpi, "*"))
+}
+
+// probe returns the batteries among the supplies matching pattern.
+func probe(pattern string) ([]*Device, error) {
+	roots, err := sysfs.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	devs := make([]*Device, 0, len(roots))
+	for _, root := range roots {
+		d := &Device{battery: battery{root: root}, Name: filepath.Base(root)}
+		if ok, err := d.isSystem(); err != nil || !ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, f := range features {
+			for _, set := range f.variables {
+				found := true
+				for _, variable := range set {
+					ok, err := d.has(variable)
+					if err != nil {
+						return nil, err
+					}
+					found = found && ok
+				}
+				if found {
+					d.capabilities |= f.capability
+					break
+				}
+			}
+		}
+		devs = append(devs, d)
+	}
+	return devs, nil
+}
+
+// device returns the device with the given name, or false if there is
+// none.
+func device(devs []*Device, name string) (*Device, bool) {
+	for _, d := range devs {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// unsupported returns the message shown when d lacks the capability c,
+// naming what it does support so that users can tell an incompatible
+// driver from a missing one.
+func unsupported(d *Device, c Capability) string {
+	name := c.String()
+	message := strings.ToUpper(name[:1]) + name[1:] + " setting not found."
+	if d != nil && d.capabilities != 0 {
+		message += fmt.Sprintf(" %s supports the %s only.", d.Name, d.capabilities)
+	}
+	return message
+}
+
+// cmdID prints the manufacturer, model and serial number of the battery.
+func cmdID(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	fields := [...]struct{ label, variable string }{
+		{"Manufacturer", "manufacturer"},
+		{"Model", "model_name"},
+		{"Serial number", "serial_number"},
+	}
+	for _, field := range fields {
+		v, err := bat.read(field.variable)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			panic(err)
+		}
+		fmt.Printf("%s: %s\n", field.label, v)
+	}
+	drivers, err := bat.drivers()
+	if err != nil {
+		panic(err)
+	}
+	for _, driver := range drivers {
+		fmt.Printf("Driver: %s\n", driver)
+	}
+
+	// The manufacture date is split across three variables, of which
+	// the day and month are often omitted.
+	date := [...]int{0, 1, 1}
+	for i, variable := range [...]string{"manufacture_year", "manufacture_month", "manufacture_day"} {
+		v, err := bat.read(variable)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			panic(err)
+		}
+		date[i], err = strconv.Atoi(v)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if date[0] == 0 {
+		return nil
+	}
+	manufactured := time.Date(date[0], time.Month(date[1]), date[2], 0, 0, 0, 0, time.UTC)
+	fmt.Printf("Manufactured: %s\n", manufactured.Format(time.DateOnly))
+	now := time.Now().UTC()
+	months := (now.Year()-manufactured.Year())*12 + int(now.Month()-manufactured.Month())
+	if now.Day() < manufactured.Day() {
+		months--
+	}
+	// Firmware occasionally reports dates in the future.
+	months = max(months, 0)
+	fmt.Printf("Age: %d years, %d months\n", months/12, months%12)
+	return nil
+}
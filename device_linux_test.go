@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// loadSupplies returns a fake sysfs holding the tree under
+// testdata/supplies/layout as its power supplies.
+func loadSupplies(t *testing.T, layout string) *fakeFS {
+	t.Helper()
+	f := &fakeFS{files: fstest.MapFS{}}
+	tree := os.DirFS(filepath.Join("testdata", "supplies", layout))
+	err := fs.WalkDir(tree, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		contents, err := fs.ReadFile(tree, path)
+		if err != nil {
+			return err
+		}
+		f.files[filepath.Join(strings.TrimPrefix(supplies, "/"), path)] = &fstest.MapFile{Data: contents}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestDevices(t *testing.T) {
+	tests := []struct {
+		layout string
+		names  []string
+		// control is the path of the threshold of the first battery,
+		// relative to the supplies, and value its contents.
+		control string
+		value   int
+	}{
+		// The battery of a wireless mouse has a device scope.
+		{layout: "thinkpad", names: []string{"BAT0"}, control: "BAT0/" + threshold, value: 80},
+		// The threshold is attached to the parent device.
+		{layout: "batt", names: []string{"BATT"}, control: "BATT/device/" + threshold, value: 90},
+		{layout: "batc", names: []string{"BATC"}, control: "BATC/" + threshold, value: 80},
+		{layout: "cmb1", names: []string{"CMB1"}, control: "CMB1/" + threshold, value: 60},
+		// Supplies without a type are told apart by name.
+		{layout: "untyped", names: []string{"BAT0"}, control: "BAT0/" + threshold, value: 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			useFS(t, loadSupplies(t, tt.layout))
+			devs, err := devices()
+			if err != nil {
+				t.Fatalf("devices: %v", err)
+			}
+			names := make([]string, len(devs))
+			for i, d := range devs {
+				names[i] = d.Name
+			}
+			if !slices.Equal(names, tt.names) {
+				t.Fatalf("devices = %q, want %q", names, tt.names)
+			}
+			d := devs[0]
+			if d.Capabilities()&HasThreshold == 0 {
+				t.Errorf("%s capabilities = %s, want the charging threshold", d.Name, d.Capabilities())
+			}
+			if got, want := d.path(threshold), filepath.Join(supplies, tt.control); got != want {
+				t.Errorf("path(%s) = %s, want %s", threshold, got, want)
+			}
+			if v, err := d.readInt(threshold); err != nil || v != tt.value {
+				t.Errorf("readInt(%s) = %d, %v, want %d", threshold, v, err, tt.value)
+			}
+		})
+	}
+}
+
+func TestParentControlWrite(t *testing.T) {
+	useFS(t, loadSupplies(t, "batt"))
+	devs, err := devices()
+	if err != nil || len(devs) == 0 {
+		t.Fatalf("devices = %v, %v", devs, err)
+	}
+	if err := devs[0].set(threshold, 70); err != nil {
+		t.Fatalf("set(70) = %v", err)
+	}
+	if v, err := devs[0].readInt(threshold); err != nil || v != 70 {
+		t.Errorf("threshold after set(70) = %d, %v, want 70", v, err)
+	}
+}
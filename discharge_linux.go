@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+)
+
+// behaviours returns the charge behaviours supported by the battery. The
+// kernel lists them on one line with the active one in brackets, for
+// example "[auto] inhibit-charge force-discharge".
+func (b *battery) behaviours() ([]string, error) {
+	v, err := b.read("charge_behaviour")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(v)
+	for i, field := range fields {
+		fields[i] = strings.Trim(field, "[]")
+	}
+	return fields, nil
+}
+
+// canForceDischarge reports whether the battery can be made to discharge
+// while on external power.
+func (d *Device) canForceDischarge() (bool, error) {
+	if d.Capabilities()&HasChargeBehaviour == 0 {
+		return false, nil
+	}
+	behaviours, err := d.behaviours()
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(behaviours, "force-discharge"), nil
+}
+
+// dischargeTo makes the battery discharge on external power until its
+// level falls to target, checking every interval, and then restores the
+// automatic behaviour. The behaviour is restored even if ctx is cancelled
+// so that the battery is never left draining.
+func (b *battery) dischargeTo(ctx context.Context, target int, interval time.Duration, progress func(level int)) (err error) {
+	if err := b.write("charge_behaviour", []byte("force-discharge")); err != nil {
+		return err
+	}
+	defer func() {
+		if restoreErr := b.write("charge_behaviour", []byte("auto")); err == nil {
+			err = restoreErr
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		level, err := b.readInt("capacity")
+		if err != nil {
+			return err
+		}
+		progress(level)
+		if level <= target {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
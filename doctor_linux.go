@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// Problem is an issue found by doctor along with its remedy, if any.
+type Problem struct {
+	Description string
+	// Remedy describes the fix as an imperative, such as "Enable it". The
+	// fix is nil if the problem cannot be fixed automatically.
+	Remedy string
+	fix    func(ctx context.Context) error
+}
+
+// vendorModules maps DMI system vendors to the module that adds the
+// charging threshold on their machines.
+var vendorModules = map[string]string{
+	"ASUSTeK COMPUTER INC.":              "asus_nb_wmi",
+	"Dell Inc.":                          "dell_wmi_sysman",
+	"Framework":                          "cros_charge_control",
+	"HUAWEI":                             "huawei_wmi",
+	"LENOVO":                             "thinkpad_acpi",
+	"LG Electronics":                     "lg_laptop",
+	"Micro-Star International Co., Ltd.": "msi_ec",
+	"System76":                           "system76_acpi",
+	"TOSHIBA":                            "toshiba_acpi",
+}
+
+// diagnose looks for problems that keep the charging threshold from
+// working or surviving a restart.
+func diagnose(ctx context.Context, bat *Device, batteries []*Device) ([]Problem, error) {
+	problems := make([]Problem, 0)
+
+	// Malformed quirks are otherwise ignored silently.
+	if _, err := loadQuirks(); err != nil {
+		problems = append(problems, Problem{
+			Description: fmt.Sprintf("The quirks in %s are ignored: %v.", quirksDir, err),
+		})
+	}
+
+	// A missing threshold is often down to the vendor module not being
+	// loaded.
+	if bat.Capabilities()&HasThreshold == 0 {
+		p := Problem{Description: missing(bat)}
+		vendor, err := readString(filepath.Join(dmi, "sys_vendor"))
+		if err == nil {
+			if module, ok := vendorModules[vendor]; ok {
+				if _, err := sysfs.Stat(filepath.Join(modules, module)); errors.Is(err, fs.ErrNotExist) {
+					p.Description = fmt.Sprintf("The %s module, which provides the charging threshold on this machine, is not loaded.", module)
+					p.Remedy = "Load " + module
+					p.fix = func(ctx context.Context) error {
+						output, err := runner.Run(exec.CommandContext(ctx, "modprobe", module))
+						if err != nil {
+							return fmt.Errorf("modprobe: %s: %w", bytes.TrimSpace(output), err)
+						}
+						return nil
+					}
+				}
+			}
+		}
+		// Nothing else can be checked without a threshold.
+		return append(problems, p), nil
+	}
+
+	// Nothing can be changed through a read-only sysfs, which is usually
+	// down to running in a container.
+	if readOnly(supplies) {
+		return append(problems, Problem{
+			Description: "/sys is mounted read-only, as it is inside a container, so the threshold can only be changed on the host.",
+		}), nil
+	}
+
+	// The configured threshold should be the current one.
+	c, _, err := findConfig()
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		err = c.override(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Threshold != 0 {
+		d := bat
+		if c.Device != "" {
+			var ok bool
+			if d, ok = device(batteries, c.Device); !ok {
+				d = nil
+			}
+		}
+		if d != nil {
+			v, err := d.read(threshold)
+			if err != nil {
+				return nil, err
+			}
+			if v != strconv.Itoa(c.Threshold) {
+				problems = append(problems, Problem{
+					Description: fmt.Sprintf("The threshold of %s is %s%% rather than the configured %d%%.", d.Name, v, c.Threshold),
+					Remedy:      fmt.Sprintf("Set it to %d%%", c.Threshold),
+					fix: func(ctx context.Context) error {
+						return d.set(threshold, c.Threshold)
+					},
+				})
+			}
+		}
+	}
+
+	// On Framework laptops the embedded controller may have been given a
+	// different limit directly, such as through framework_tool, which
+	// then takes precedence over the threshold.
+	upper, ok, err := sustained(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		v, err := bat.readInt(threshold)
+		if err != nil {
+			return nil, err
+		}
+		if upper != v {
+			problems = append(problems, Problem{
+				Description: fmt.Sprintf("The embedded controller holds %s at %d%% rather than the threshold of %d%%.", bat.Name, upper, v),
+				Remedy:      fmt.Sprintf("Set it to %d%% again", v),
+				fix: func(ctx context.Context) error {
+					return bat.set(threshold, v)
+				},
+			})
+		}
+	}
+
+	// Dell firmware stops charging at the level of its own charge mode,
+	// which the BIOS setup can change behind the back of the threshold.
+	limit, setting, ok, err := dellLimit()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		v, err := bat.readInt(threshold)
+		if err != nil {
+			return nil, err
+		}
+		if limit != v {
+			problems = append(problems, Problem{
+				Description: fmt.Sprintf("The BIOS stops charging %s at %d%% (%s) rather than at the threshold of %d%%.", bat.Name, limit, setting, v),
+				Remedy:      fmt.Sprintf("Set the BIOS to %d%%", v),
+				fix: func(ctx context.Context) error {
+					return setDellLimit(v)
+				},
+			})
+		}
+	}
+
+	// Installed units should be enabled, readable by systemd and restore
+	// the current thresholds.
+	if elogind() {
+		return problems, nil
+	}
+	installed := make([]string, 0)
+	for _, event := range events {
+		if _, err := os.Stat(filepath.Join(defaultUnits.Dir, defaultUnits.name(event))); err == nil {
+			installed = append(installed, event)
+		}
+	}
+	if len(installed) == 0 {
+		return append(problems, Problem{
+			Description: "The charging threshold is not persisted between restarts.",
+			Remedy:      "Persist it",
+			fix:         func(ctx context.Context) error { _, err := persist(ctx, defaultUnits, batteries); return err },
+		}), nil
+	}
+	want, err := desired(ctx, batteries)
+	if err != nil {
+		return nil, err
+	}
+	stale := false
+	for _, event := range installed {
+		service := defaultUnits.name(event)
+		path := filepath.Join(defaultUnits.Dir, service)
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		stale = stale || !bytes.Equal(contents, want[event])
+		output, _ := runner.Run(command(ctx, "systemctl", "is-enabled", service))
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if state := string(bytes.TrimSpace(output)); state != "enabled" {
+			problems = append(problems, Problem{
+				Description: fmt.Sprintf("%s is %s.", service, state),
+				Remedy:      "Enable it",
+				fix: func(ctx context.Context) error {
+					_, err := systemctl(ctx, "enable", service)
+					return err
+				},
+			})
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().Perm() != 0o644 {
+			problems = append(problems, Problem{
+				Description: fmt.Sprintf("%s has the permissions %s rather than -rw-r--r--.", path, info.Mode().Perm()),
+				Remedy:      "Correct its permissions and SELinux label",
+				fix: func(ctx context.Context) error {
+					if err := os.Chmod(path, 0o644); err != nil {
+						return err
+					}
+					return relabel(ctx, path)
+				},
+			})
+		}
+	}
+	if stale {
+		problems = append(problems, Problem{
+			Description: "The persistence units do not restore the current thresholds.",
+			Remedy:      "Rewrite them",
+			fix:         func(ctx context.Context) error { _, err := persist(ctx, defaultUnits, batteries); return err },
+		})
+	}
+	return problems, nil
+}
+
+// cmdDoctor reports the problems that keep the threshold from working and
+// fixes them on request.
+func cmdDoctor(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fix := flags.Bool("fix", false, ignore)
+	yes := flags.Bool("yes", false, ignore)
+	markdown := flags.Bool("markdown", false, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() != 0 || (*yes && !*fix) || (*markdown && *fix) {
+		return errUsage
+	}
+	if *fix && !*yes && !interactive() {
+		return userError("Fixes are confirmed interactively. Pass `--yes` to apply them without a\n" +
+			"terminal.")
+	}
+	problems, err := diagnose(ctx, bat, batteries)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problems found.")
+		return nil
+	}
+	if *markdown {
+		rows := make([][2]string, len(problems))
+		for i, p := range problems {
+			rows[i] = [2]string{p.Description, p.Remedy}
+		}
+		if err := markdownTable(os.Stdout, [2]string{"Problem", "Fix"}, rows); err != nil {
+			return err
+		}
+		return errFailed
+	}
+	if !*fix {
+		fixable := false
+		for _, p := range problems {
+			fmt.Println(p.Description)
+			fixable = fixable || p.fix != nil
+		}
+		if fixable {
+			fmt.Println("Run `sudo bat doctor --fix` to fix them.")
+		}
+		return errFailed
+	}
+	for _, p := range problems {
+		fmt.Println(p.Description)
+		if p.fix == nil || (!*yes && !confirm(p.Remedy+"?")) {
+			continue
+		}
+		if err := p.fix(ctx); err != nil {
+			return err
+		}
+	}
+	// Fixing one problem may reveal or resolve another, such as units
+	// that become stale once the threshold is corrected.
+	batteries, err = devices()
+	if err != nil {
+		panic(err)
+	}
+	if d, ok := device(batteries, bat.Name); ok {
+		bat = d
+	}
+	problems, err = diagnose(ctx, bat, batteries)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("All problems fixed.")
+		return nil
+	}
+	fmt.Println("Remaining problems:")
+	for _, p := range problems {
+		fmt.Println(p.Description)
+	}
+	return errFailed
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+var modules = filepath.Join("/", "sys", "module")
+
+// extensions are the modules that attach charge control variables to a
+// battery driven by another driver, usually the generic ACPI one, which
+// determine the features that are expected to work.
+var extensions = [...]string{
+	"asus_wmi",
+	"cros_charge_control",
+	"dell_laptop",
+	"dell_wmi_sysman",
+	"framework_laptop",
+	"huawei_wmi",
+	"ideapad_laptop",
+	"lg_laptop",
+	"msi_ec",
+	"samsung_laptop",
+	"system76_acpi",
+	"thinkpad_acpi",
+	"toshiba_acpi",
+}
+
+// Driver is a kernel module backing a battery. Version is empty for
+// modules built into the kernel without a version of their own.
+type Driver struct {
+	Name, Version string
+}
+
+func (d Driver) String() string {
+	if d.Version == "" {
+		return d.Name
+	}
+	return d.Name + " " + d.Version
+}
+
+// drivers returns the driver of the battery's device followed by the
+// loaded modules that extend it.
+func (d *Device) drivers() ([]Driver, error) {
+	drivers := make([]Driver, 0)
+	link, err := os.Readlink(d.path(filepath.Join("device", "driver")))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		drivers = append(drivers, driver(filepath.Base(link)))
+	}
+	for _, name := range extensions {
+		if _, err := sysfs.Stat(filepath.Join(modules, name)); err == nil {
+			drivers = append(drivers, driver(name))
+		}
+	}
+	return drivers, nil
+}
+
+// driver returns the module with its version, where it has one.
+func driver(name string) Driver {
+	version, err := readString(filepath.Join(modules, name, "version"))
+	if err != nil {
+		return Driver{Name: name}
+	}
+	return Driver{Name: name, Version: version}
+}
@@ -0,0 +1,11 @@
+#!{{.Shell}}
+# Restores the battery charging threshold after waking up, and at boot
+# when run without arguments. Installed by `bat persist` and removed by
+# `bat reset`.
+case ${1:-post} in
+post)
+{{if .Delay}}	sleep {{.Delay}}
+{{end}}{{range .Settings}}	echo {{.Value}} > {{.Path}}
+{{end}}{{if .OnAC}}	{{.Path}} on-ac {{.OnAC}}
+{{end}}	;;
+esac
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+)
+
+var (
+	// hooks are run by elogind with pre or post and the sleep action as
+	// arguments, in place of the systemd sleep targets.
+	hooks = filepath.Join("/", "lib", "elogind", "system-sleep")
+	// local holds the scripts run by OpenRC at boot.
+	local = filepath.Join("/", "etc", "local.d")
+
+	//go:embed elogind.sh
+	hook string
+)
+
+// elogind reports whether the system uses elogind rather than systemd, in
+// which case persistence relies on its sleep hooks.
+func elogind() bool {
+	if _, err := exec.LookPath("systemctl"); err == nil {
+		return false
+	}
+	info, err := os.Stat(hooks)
+	return err == nil && info.IsDir()
+}
+
+// scripts returns the paths of the scripts that restore the thresholds:
+// the sleep hook and, on OpenRC, a boot script.
+func scripts() []string {
+	paths := []string{filepath.Join(hooks, "bat")}
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		paths = append(paths, filepath.Join(local, "bat.start"))
+	}
+	return paths
+}
+
+// persistElogind installs scripts that restore the current thresholds
+// after waking up and at boot. Scripts already in the desired state are
+// left untouched.
+func persistElogind(batteries []*Device) ([]Outcome, error) {
+	settings, err := current(batteries)
+	if err != nil {
+		return nil, err
+	}
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, errNoShell
+		}
+		return nil, err
+	}
+	s := Service{Shell: shell, Settings: settings, Delay: machine().ResumeDelay}
+	if err := s.onAC(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("hook").Parse(hook))
+	if err := tmpl.Execute(&buf, s); err != nil {
+		return nil, err
+	}
+	outcomes := make([]Outcome, 0, 2)
+	for _, path := range scripts() {
+		contents := buf.Bytes()
+		existing, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return outcomes, err
+		}
+		action := "unchanged"
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			action = "created"
+		case !bytes.Equal(existing, contents):
+			action = "updated"
+		}
+		if action != "unchanged" {
+			if err := os.WriteFile(path, contents, 0o755); err != nil {
+				os.Remove(path)
+				return outcomes, err
+			}
+		}
+		outcomes = append(outcomes, Outcome{Unit: path, Action: action})
+	}
+	return outcomes, nil
+}
+
+// resetElogind removes the scripts installed by persistElogind.
+func resetElogind() ([]Outcome, error) {
+	outcomes := make([]Outcome, 0, 2)
+	for _, path := range scripts() {
+		if err := os.Remove(path); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return outcomes, err
+		}
+		outcomes = append(outcomes, Outcome{Unit: path, Action: "removed"})
+	}
+	return outcomes, nil
+}
+
+// applyElogind runs the sleep hook as elogind would after waking up,
+// confirming that it works.
+func applyElogind(ctx context.Context) error {
+	_, err := runner.Run(exec.CommandContext(ctx, filepath.Join(hooks, "bat"), "post", "suspend"))
+	return err
+}
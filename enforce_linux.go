@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// rivals are the names of programs known to change the charging
+// threshold, which are the likely culprits when it changes behind the
+// daemon's back.
+var rivals = [...]string{
+	"asusd",
+	"auto-cpufreq",
+	"gnome-settings-daemon",
+	"power-profiles-daemon",
+	"slimbookbattery",
+	"tlp",
+	"tuned",
+	"upowerd",
+}
+
+// suspects returns the names of the running programs that may have
+// changed the threshold. sysfs does not record the writer so this is only
+// a hint.
+func suspects() []string {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0)
+	for _, entry := range entries {
+		if entry.Name()[0] < '0' || entry.Name()[0] > '9' {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "comm"))
+		if err != nil {
+			// The process may have exited since.
+			continue
+		}
+		name := strings.TrimSpace(string(comm))
+		if slices.Contains(rivals[:], name) && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// revert restores the thresholds pinned by the daemon on batteries whose
+// threshold was changed by another program. The thresholds are pinned
+// the first time they are read and whenever the daemon itself changes
+// them. Failures are logged and retried on the next reading.
+func (d *daemon) revert(states []State) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pinned == nil {
+		d.pinned = make(map[string]int)
+	}
+	for _, s := range states {
+		if s.Status == removed || s.Threshold == 0 {
+			continue
+		}
+		want, ok := d.pinned[s.Battery]
+		if !ok {
+			d.pinned[s.Battery] = s.Threshold
+			continue
+		}
+		if s.Threshold == want {
+			continue
+		}
+		b, ok := device(d.batteries, s.Battery)
+		if !ok {
+			continue
+		}
+		// The daemon may have set it since the state was read.
+		if v, err := b.read(threshold); err == nil && v == strconv.Itoa(want) {
+			continue
+		}
+		d.log.Warn("threshold changed externally", "device", s.Battery, "operation", "enforce",
+			"value", s.Threshold, "want", want, "suspects", strings.Join(suspects(), ","))
+		if err := b.set(threshold, want); err != nil {
+			d.log.Error("reverting threshold failed", "device", s.Battery, "operation", "enforce",
+				"value", want, "error", err)
+			continue
+		}
+		d.log.Info("reverted threshold", "device", s.Battery, "operation", "enforce", "value", want)
+	}
+}
+
+// pin records the threshold set by the daemon so that it is not reverted.
+func (d *daemon) pin(battery string, value int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pinned != nil {
+		d.pinned[battery] = value
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EventRecord is an event as printed by `events --json`, one object per
+// line. The field names are relied on by scripts and must not change.
+type EventRecord struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"event"`
+	State
+	Previous string `json:"previous"`
+}
+
+// current returns the value of the state that the event is about.
+func (e Event) current() string {
+	switch e.Kind {
+	case "status":
+		return e.State.Status
+	case "threshold":
+		return strconv.Itoa(e.State.Threshold)
+	}
+	return strconv.Itoa(e.State.Capacity)
+}
+
+// watch writes the events of the batteries to w as they happen until ctx
+// is cancelled or, if once is set, until the first change. Like the
+// daemon it reads the batteries on uevents, falling back to polling at the
+// interval.
+func watch(ctx context.Context, w io.Writer, batteries []*Device, levels []int, asJSON, once bool) error {
+	d := &daemon{
+		batteries: batteries,
+		levels:    levels,
+		log:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	updates, err := uevents(ctx)
+	if err != nil {
+		// Netlink may be unavailable in containers.
+		updates = nil
+	}
+	const interval = 5 * time.Second
+	enc := json.NewEncoder(w)
+	var previous []State
+	for {
+		if err := d.rescan(); err != nil {
+			return err
+		}
+		states, err := d.states()
+		if err != nil {
+			return err
+		}
+		events := transitions(previous, states, levels)
+		now := time.Now()
+		for _, e := range events {
+			if asJSON {
+				err = enc.Encode(EventRecord{Time: now, Kind: e.Kind, State: e.State, Previous: e.Previous})
+			} else {
+				_, err = fmt.Fprintf(w, "%s  %s  %s  %s -> %s\n", now.Format(time.RFC3339), e.State.Battery, e.Kind, e.Previous, e.current())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if once && len(events) > 0 {
+			return nil
+		}
+		previous = states
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		case _, ok := <-updates:
+			if !ok {
+				updates = nil
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(debounce):
+			}
+			// Drain events received while waiting.
+			select {
+			case <-updates:
+			default:
+			}
+		}
+	}
+}
+
+// cmdEvents prints changes to the batteries as they happen.
+func cmdEvents(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("events", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, ignore)
+	follow := flags.Bool("follow", true, ignore)
+	once := flags.Bool("once", false, ignore)
+	at := flags.String("at", "", ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	// --follow is the default, so --follow=false is the same as --once.
+	*once = *once || !*follow
+	levels, err := parseLevels(*at)
+	if err != nil {
+		return userError("Levels should be of the form `20,80`, between 1 and 100.")
+	}
+	if err := watch(ctx, os.Stdout, batteries, levels, *asJSON, *once); err != nil {
+		panic(err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var errUnits = errors.New("invalid units")
+
+// Numbers formats the quantities printed by the commands according to the
+// locale and the preferred units.
+type Numbers struct {
+	// Milli expresses power and voltage in mW and mV rather than W and V.
+	Milli bool
+	// Decimal is the decimal separator.
+	Decimal string
+	// PercentPrefix places the percent sign before the number, as in
+	// Turkish, and PercentSpace separates them with a space, as in French
+	// and German.
+	PercentPrefix, PercentSpace bool
+}
+
+// numbers is the format used for output, set from the environment.
+var numbers = Numbers{Decimal: "."}
+
+// commaLocales are the languages that use a decimal comma.
+var commaLocales = [...]string{
+	"cs", "da", "de", "es", "fi", "fr", "id", "it", "nb", "nl", "pl",
+	"pt", "ro", "ru", "sk", "sv", "tr", "uk",
+}
+
+// spaceLocales are the languages that separate the percent sign with a
+// space.
+var spaceLocales = [...]string{"cs", "de", "fi", "fr", "nb", "sk", "sv"}
+
+// locale returns the language and territory of the locale in LC_ALL, the
+// category or LANG, in that order.
+func locale(category string) (language, territory string) {
+	var s string
+	for _, name := range [...]string{"LC_ALL", category, "LANG"} {
+		if s = os.Getenv(name); s != "" {
+			break
+		}
+	}
+	// Locales are of the form language_TERRITORY.codeset@modifier.
+	s, _, _ = strings.Cut(s, "@")
+	s, _, _ = strings.Cut(s, ".")
+	language, territory, _ = strings.Cut(s, "_")
+	return language, territory
+}
+
+// language returns the language of the locale in LC_ALL, the category or
+// LANG, in that order.
+func language(category string) string {
+	language, _ := locale(category)
+	return language
+}
+
+// twelveHourLocales are the territories whose clocks are read in 12 hours.
+var twelveHourLocales = [...]string{"AU", "CA", "EG", "IN", "NZ", "PH", "PK", "SA", "US"}
+
+// clockLayout returns the layout of times of day for clock, which is 12h,
+// 24h or empty to follow the locale in LC_ALL, LC_TIME or LANG.
+func clockLayout(clock string) string {
+	if clock == "" {
+		clock = "24h"
+		if _, territory := locale("LC_TIME"); slices.Contains(twelveHourLocales[:], territory) {
+			clock = "12h"
+		}
+	}
+	if clock == "12h" {
+		return "3:04 PM"
+	}
+	return "15:04"
+}
+
+// localNumbers returns the format for the locale in LC_ALL, LC_NUMERIC or
+// LANG, in that order, and the units in BAT_UNITS, which is either si
+// (the default) or milli.
+func localNumbers() (Numbers, error) {
+	n := Numbers{Decimal: "."}
+	switch os.Getenv("BAT_UNITS") {
+	case "", "si":
+	case "milli":
+		n.Milli = true
+	default:
+		return n, errUnits
+	}
+	language := language("LC_NUMERIC")
+	for _, l := range commaLocales {
+		if language == l {
+			n.Decimal = ","
+		}
+	}
+	for _, l := range spaceLocales {
+		if language == l {
+			n.PercentSpace = true
+		}
+	}
+	n.PercentPrefix = language == "tr"
+	return n, nil
+}
+
+// float formats f with the given number of decimals.
+func (n Numbers) float(f float64, decimals int) string {
+	return strings.Replace(strconv.FormatFloat(f, 'f', decimals, 64), ".", n.Decimal, 1)
+}
+
+// Watts formats a power given in watts.
+func (n Numbers) Watts(w float64) string {
+	if n.Milli {
+		return n.float(w*1e3, 0) + " mW"
+	}
+	return n.float(w, 2) + " W"
+}
+
+// Volts formats a voltage given in volts.
+func (n Numbers) Volts(v float64) string {
+	if n.Milli {
+		return n.float(v*1e3, 0) + " mV"
+	}
+	return n.float(v, 2) + " V"
+}
+
+// Celsius formats a temperature given in degrees Celsius.
+func (n Numbers) Celsius(c float64) string {
+	return n.float(c, 1) + " °C"
+}
+
+// Percent formats a percentage with the given number of decimals.
+func (n Numbers) Percent(p float64, decimals int) string {
+	s := n.float(p, decimals)
+	switch {
+	case n.PercentPrefix:
+		return "%" + s
+	case n.PercentSpace:
+		return s + " %"
+	}
+	return s + "%"
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sustainer matches the battery sustainer, the charge limit held by the
+// embedded controller, in the output of `ectool chargecontrol`, such as
+// "Battery sustainer = on (80% ~ 85%)".
+var sustainer = regexp.MustCompile(`Battery sustainer = on \((\d+)% ~ (\d+)%\)`)
+
+// framework reports whether the machine is a Framework laptop with its
+// ChromeOS embedded controller driver loaded. The standard threshold is
+// backed by the controller's battery sustainer, which ectool can query
+// for more than the kernel exposes.
+func framework() bool {
+	vendor, err := readString(filepath.Join(dmi, "sys_vendor"))
+	if err != nil || !strings.HasPrefix(vendor, "Framework") {
+		return false
+	}
+	_, err = sysfs.Stat(filepath.Join(chromeos, "cros_ec"))
+	return err == nil
+}
+
+// ectool runs ectool with the given arguments and returns its output.
+func ectool(ctx context.Context, args ...string) ([]byte, error) {
+	output, err := runner.Run(exec.CommandContext(ctx, "ectool", args...))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ectool: %s: %w", bytes.TrimSpace(output), err)
+	}
+	return output, nil
+}
+
+// ecState returns the charge control and charger state reported by the
+// embedded controller as pairs of names and values in the order printed,
+// or nil if this is not a Framework laptop or ectool is not installed.
+// Querying the controller usually requires root.
+func ecState(ctx context.Context) ([][2]string, error) {
+	if !framework() {
+		return nil, nil
+	}
+	if _, err := exec.LookPath("ectool"); err != nil {
+		return nil, nil
+	}
+	rows := make([][2]string, 0)
+	for _, args := range [...][]string{{"chargecontrol"}, {"chargestate", "show"}} {
+		output, err := ectool(ctx, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			name, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			name = strings.ReplaceAll(strings.TrimSpace(name), "_", " ")
+			rows = append(rows, [2]string{name, strings.TrimSpace(value)})
+		}
+	}
+	return rows, nil
+}
+
+// sustained returns the upper limit at which the embedded controller holds
+// the battery, or false if the sustainer is off or ectool is unavailable.
+func sustained(ctx context.Context) (int, bool, error) {
+	if !framework() {
+		return 0, false, nil
+	}
+	if _, err := exec.LookPath("ectool"); err != nil {
+		return 0, false, nil
+	}
+	output, err := ectool(ctx, "chargecontrol")
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, false, ctx.Err()
+		}
+		// Without root the controller cannot be queried, which is not a
+		// problem with the threshold.
+		return 0, false, nil
+	}
+	m := sustainer.FindSubmatch(output)
+	if m == nil {
+		return 0, false, nil
+	}
+	upper, err := strconv.Atoi(string(m[2]))
+	return upper, err == nil, err
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HealthSample is a reading of the full and design charge of the battery
+// at a point in time.
+type HealthSample struct {
+	Time         time.Time
+	Full, Design int
+}
+
+// Percent returns the full charge as a percentage of the design charge.
+func (s HealthSample) Percent() float64 {
+	return float64(s.Full) * 100 / float64(s.Design)
+}
+
+// healthInterval is the minimum time between recorded health samples.
+// Full charge changes slowly so more frequent samples only add noise.
+const healthInterval = 24 * time.Hour
+
+// month is the average length of a month used to express the fade rate.
+const month = 730 * time.Hour
+
+// healthPath returns the path of the file holding the health history of
+// the device.
+func healthPath(name string) (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "health-"+name), nil
+}
+
+// loadHealth returns the health history in the file at path in
+// chronological order, or none if it does not exist.
+func loadHealth(path string) ([]HealthSample, error) {
+	return loadLines(path, func(line string) (HealthSample, bool) {
+		var (
+			seconds int64
+			s       HealthSample
+		)
+		if _, err := fmt.Sscanf(line, "%d %d %d", &seconds, &s.Full, &s.Design); err != nil || s.Design == 0 {
+			return s, false
+		}
+		s.Time = time.Unix(seconds, 0)
+		return s, true
+	})
+}
+
+// saveHealth replaces the health history in the file at path.
+func saveHealth(path string, samples []HealthSample) error {
+	return saveLines(path, samples, func(s HealthSample) string {
+		return fmt.Sprintf("%d %d %d", s.Time.Unix(), s.Full, s.Design)
+	})
+}
+
+// recordHealth appends the current reading to the health history of the
+// device unless the last sample is more recent than the interval, and
+// returns the history in chronological order. Old samples are downsampled
+// as they age.
+func (d *Device) recordHealth(now time.Time) ([]HealthSample, error) {
+	path, err := healthPath(d.Name)
+	if err != nil {
+		return nil, err
+	}
+	full, design, err := d.full()
+	if err != nil {
+		return nil, err
+	}
+	if design == 0 {
+		return nil, errors.New("design charge is zero")
+	}
+	samples, err := loadHealth(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) > 0 && now.Sub(samples[len(samples)-1].Time) < healthInterval {
+		return samples, nil
+	}
+	samples = downsample(append(samples, HealthSample{Time: now, Full: full, Design: design}), now)
+	return samples, saveHealth(path, samples)
+}
+
+// Trend is the rate at which the health of the battery fades.
+type Trend struct {
+	// PerMonth is the change in health in percentage points per month,
+	// negative as the battery degrades.
+	PerMonth float64
+	// Replace is the extrapolated time at which health falls to the
+	// recommended replacement level, or the zero time if it is not
+	// falling.
+	Replace time.Time
+}
+
+// replacement is the health in percent below which a battery is commonly
+// considered worn out.
+const replacement = 80
+
+// minSpan is the shortest history from which a trend is reported.
+const minSpan = 7 * 24 * time.Hour
+
+// trend fits a line through the samples. It returns false if the history
+// is too short for the fit to be meaningful.
+func trend(samples []HealthSample) (Trend, bool) {
+	if len(samples) < 2 || samples[len(samples)-1].Time.Sub(samples[0].Time) < minSpan {
+		return Trend{}, false
+	}
+	xs, ys := make([]float64, len(samples)), make([]float64, len(samples))
+	for i, s := range samples {
+		xs[i], ys[i] = s.Time.Sub(samples[0].Time).Hours(), s.Percent()
+	}
+	perHour, ok := regress(xs, ys)
+	if !ok {
+		return Trend{}, false
+	}
+	t := Trend{PerMonth: perHour * month.Hours()}
+	last := samples[len(samples)-1]
+	if perHour < 0 {
+		hours := (last.Percent() - replacement) / -perHour
+		t.Replace = last.Time.Add(time.Duration(max(hours, 0) * float64(time.Hour)))
+	}
+	return t, true
+}
+
+// sparkline renders the values as a line of block characters scaled
+// between their minimum and maximum.
+func sparkline(values []float64) string {
+	levels := []rune("▁▂▃▄▅▆▇█")
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := len(levels) - 1
+		if hi > lo {
+			i = int((v - lo) / (hi - lo) * float64(len(levels)-1))
+		}
+		b.WriteRune(levels[i])
+	}
+	return b.String()
+}
+
+// cmdHealth prints the health of the battery, or its trend.
+func cmdHealth(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	if bat.Capabilities()&HasHealth == 0 {
+		return userError(unsupported(bat, HasHealth))
+	}
+	flags := flag.NewFlagSet("health", flag.ExitOnError)
+	flags.Usage = flag.Usage
+	trending := flags.Bool("trend", false, ignore)
+	since := flags.String("since", "", ignore)
+	until := flags.String("until", "", ignore)
+	last := flags.String("last", "", ignore)
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	if !*trending && *since+*until+*last != "" {
+		return userError("The --since, --until and --last flags only apply to --trend.")
+	}
+	if !*trending {
+		health, err := bat.health()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(health)
+		return nil
+	}
+	span, err := historyRange(*since, *until, *last)
+	if err != nil {
+		return err
+	}
+	samples, err := bat.recordHealth(time.Now())
+	if err != nil {
+		panic(err)
+	}
+	samples = slices.DeleteFunc(samples, func(s HealthSample) bool { return !span.contains(s.Time) })
+	if len(samples) == 0 {
+		fmt.Println("No health recorded in that range.")
+		return nil
+	}
+	latest := samples[len(samples)-1]
+	fmt.Printf("Health: %s\n", numbers.Percent(latest.Percent(), 1))
+	t, ok := trend(samples)
+	if !ok {
+		fmt.Println("Not enough history for a trend yet. Health is recorded at most once a\n" +
+			"day each time `bat health --trend` runs.")
+		return nil
+	}
+	fmt.Printf("Fade: %s per month\n", numbers.Percent(-t.PerMonth, 2))
+	if !t.Replace.IsZero() {
+		fmt.Printf("Reaches %d%%: %s\n", replacement, t.Replace.Format("2006-01-02"))
+	}
+	percents := make([]float64, len(samples))
+	for i, s := range samples {
+		percents[i] = s.Percent()
+	}
+	fmt.Println(sparkline(percents))
+	return nil
+}
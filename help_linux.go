@@ -0,0 +1,20 @@
+package main
+
+import "embed"
+
+// translations holds the help document in other languages, named after
+// the language as in help.de.txt. Translations are welcome as pull
+// requests and should follow changes to help.txt.
+//
+//go:embed help.*.txt
+var translations embed.FS
+
+// localUsage returns the help document in the language of LC_MESSAGES,
+// falling back to English if there is no translation.
+func localUsage() string {
+	text, err := translations.ReadFile("help." + language("LC_MESSAGES") + ".txt")
+	if err != nil {
+		return usage
+	}
+	return string(text)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Helper is the configuration of the socket-activated service that
+// performs threshold writes on behalf of unprivileged users.
+type Helper struct {
+	Group, Path, Socket string
+}
+
+const (
+	helperSocket  = "bat-helper.socket"
+	helperService = "bat-helper@.service"
+)
+
+var (
+	socket = filepath.Join("/", "run", "bat.sock")
+
+	//go:embed helper.socket
+	helperSocketUnit string
+
+	//go:embed helper.service
+	helperServiceUnit string
+)
+
+// delegate asks the helper to set the threshold of the named battery. The
+// error wraps fs.ErrNotExist or unix.EACCES if the helper is not installed
+// or the user is not allowed to use it.
+func delegate(ctx context.Context, name, setting string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintf(conn, "%s=%s\n", name, setting); err != nil {
+		return err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if reply = strings.TrimSpace(reply); reply != "ok" {
+		return errors.New(reply)
+	}
+	return nil
+}
+
+// elevate sets the threshold of the named battery through pkexec, which
+// asks the polkit agent of the desktop for authentication, for when the
+// helper is not installed. The error wraps exec.ErrNotFound if pkexec is
+// not installed, or unix.EACCES if authentication was refused.
+func elevate(ctx context.Context, name, setting string) error {
+	pkexec, err := exec.LookPath("pkexec")
+	if err != nil {
+		return err
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	output, err := runner.Run(exec.CommandContext(ctx, pkexec, path, "threshold", "--each", name+"="+setting))
+	if err != nil {
+		// pkexec exits with 126 if the dialog was dismissed and 127 if the
+		// user is not authorised.
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && (exit.ExitCode() == 126 || exit.ExitCode() == 127) {
+			err = unix.EACCES
+		}
+		return fmt.Errorf("pkexec: %s: %w", bytes.TrimSpace(output), err)
+	}
+	return nil
+}
+
+// serve handles a single request read from r, as passed in by systemd, and
+// writes the outcome to w. Requests are of the form BAT0=80, and changes
+// are recorded as made by the user uid.
+func serve(r io.Reader, w io.Writer, batteries []*Device, uid int) {
+	request, err := bufio.NewReader(io.LimitReader(r, 64)).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		fmt.Fprintln(w, err)
+		return
+	}
+	name, setting, ok := strings.Cut(strings.TrimSpace(request), "=")
+	if !ok {
+		fmt.Fprintln(w, "malformed request")
+		return
+	}
+	// Only accept known batteries so that the request cannot be used to
+	// write elsewhere.
+	bat, ok := device(batteries, name)
+	if !ok {
+		fmt.Fprintf(w, "unknown battery %q\n", name)
+		return
+	}
+	i, err := strconv.Atoi(setting)
+	if err != nil || i < 1 || i > 100 {
+		fmt.Fprintf(w, "invalid threshold %q\n", setting)
+		return
+	}
+	if q, ok := machineQuirk(); ok && !slices.Contains(q.Values, i) {
+		fmt.Fprintln(w, q.message(i))
+		return
+	}
+	if err := bat.set(threshold, i); err != nil {
+		fmt.Fprintln(w, err)
+		return
+	}
+	// The threshold is set either way, so the request still succeeds.
+	recordChange(name, uid, i, time.Now())
+	fmt.Fprintln(w, "ok")
+}
+
+// install writes and enables the helper units, granting group access to
+// the socket.
+func install(ctx context.Context, group string) error {
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	h := Helper{Group: group, Path: path, Socket: socket}
+	units := [...]struct{ name, text string }{
+		{helperService, helperServiceUnit},
+		{helperSocket, helperSocketUnit},
+	}
+	for _, unit := range units {
+		var buf bytes.Buffer
+		tmpl := template.Must(template.New(unit.name).Parse(unit.text))
+		if err := tmpl.Execute(&buf, h); err != nil {
+			return err
+		}
+		if err := checkUnit("/", unit.name, buf.Bytes()); err != nil {
+			return err
+		}
+		name := filepath.Join(services, unit.name)
+		if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+			os.Remove(name)
+			return err
+		}
+		if err := relabel(ctx, name); err != nil {
+			return err
+		}
+	}
+	_, err = systemctl(ctx, "enable", "--now", helperSocket)
+	return err
+}
+
+// uninstall disables and removes the helper units.
+func uninstall(ctx context.Context) error {
+	output, err := systemctl(ctx, "disable", "--now", helperSocket)
+	if err != nil && !bytes.Contains(output, []byte("does not exist")) {
+		return err
+	}
+	for _, name := range [...]string{helperSocket, helperService} {
+		err := os.Remove(filepath.Join(services, name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// cmdHelper installs, removes or serves the helper.
+func cmdHelper(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "install":
+		group := args[1]
+		if err := install(ctx, group); err != nil {
+			return err
+		}
+		fmt.Printf("Helper installed. Members of the `%s` group can now set the charging\n"+
+			"threshold without `sudo`.\n", group)
+	case len(args) == 1 && args[0] == "remove":
+		if err := uninstall(ctx); err != nil {
+			return err
+		}
+		fmt.Println("Helper removed.")
+	case len(args) == 1 && args[0] == "serve":
+		// Invoked by systemd with the connection as standard input and
+		// output.
+		uid := -1
+		if cred, err := unix.GetsockoptUcred(int(os.Stdin.Fd()), unix.SOL_SOCKET, unix.SO_PEERCRED); err == nil {
+			uid = int(cred.Uid)
+		}
+		serve(os.Stdin, os.Stdout, batteries, uid)
+	default:
+		return errUsage
+	}
+	return nil
+}
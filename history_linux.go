@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// recent is the age up to which health samples are kept at full
+// resolution. Older ones are averaged per week, which is ample for a
+// trend measured in months.
+const (
+	recent = 30 * 24 * time.Hour
+	week   = 7 * 24 * time.Hour
+)
+
+// downsample replaces the samples older than recent with their weekly
+// averages, timestamped at the last sample of each week. The samples must
+// be in chronological order.
+func downsample(samples []HealthSample, now time.Time) []HealthSample {
+	weekOf := func(t time.Time) int64 { return t.Unix() / int64(week.Seconds()) }
+	out := make([]HealthSample, 0, len(samples))
+	// full, design and n are the sums and count of the samples averaged
+	// into the last one kept.
+	var full, design, n int
+	for _, s := range samples {
+		if now.Sub(s.Time) > recent && len(out) > 0 && weekOf(s.Time) == weekOf(out[len(out)-1].Time) {
+			full, design, n = full+s.Full, design+s.Design, n+1
+			out[len(out)-1] = HealthSample{Time: s.Time, Full: full / n, Design: design / n}
+			continue
+		}
+		out = append(out, s)
+		full, design, n = s.Full, s.Design, 1
+	}
+	return out
+}
+
+// prune drops the health samples of the batteries and the benchmark
+// results taken before cutoff, and downsamples the remaining health
+// samples. It returns the number of entries removed.
+func prune(batteries []*Device, cutoff, now time.Time) (int, error) {
+	removed := 0
+	for _, b := range batteries {
+		path, err := healthPath(b.Name)
+		if err != nil {
+			return removed, err
+		}
+		samples, err := loadHealth(path)
+		if err != nil {
+			return removed, err
+		}
+		kept := make([]HealthSample, 0, len(samples))
+		for _, s := range samples {
+			if !s.Time.Before(cutoff) {
+				kept = append(kept, s)
+			}
+		}
+		kept = downsample(kept, now)
+		if len(kept) == len(samples) {
+			continue
+		}
+		if err := saveHealth(path, kept); err != nil {
+			return removed, err
+		}
+		removed += len(samples) - len(kept)
+	}
+
+	rs, err := loadResults()
+	if err != nil || len(rs) == 0 {
+		return removed, err
+	}
+	kept := make([]Result, 0, len(rs))
+	for _, r := range rs {
+		if !r.Time.Before(cutoff) {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(rs) {
+		return removed, nil
+	}
+	return removed + len(rs) - len(kept), saveResults(kept)
+}
+
+// cmdHistory prunes or imports the recorded history.
+func cmdHistory(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	days := flags.Int("days", -1, ignore)
+	label := flags.String("label", "upower", ignore)
+	flags.Usage = flag.Usage
+	if len(args) < 1 || (args[0] != "prune" && args[0] != "import") {
+		return errUsage
+	}
+	flags.Parse(args[1:])
+	// --days only applies to prune and --label to import.
+	other := "label"
+	if args[0] == "import" {
+		other = "days"
+	}
+	misplaced := false
+	flags.Visit(func(f *flag.Flag) {
+		misplaced = misplaced || f.Name == other
+	})
+	if misplaced {
+		return userError(fmt.Sprintf("The `--%s` flag does not apply to `history %s`.", other, args[0]))
+	}
+	if args[0] == "import" {
+		if flags.NArg() > 1 {
+			return errUsage
+		}
+		dir := upowerHistory
+		if flags.NArg() == 1 {
+			dir = flags.Arg(0)
+		}
+		n, err := importUpower(bat, dir, *label)
+		if errors.Is(err, errNoHistory) {
+			return userError(fmt.Sprintf("No upower history found in %s.", dir))
+		}
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Imported %d days of discharge rates. Run `bat benchmark --list` to see them.\n", n)
+		return nil
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	// The flag takes precedence over the configuration file, which is
+	// optional.
+	if *days < 0 {
+		c, _ := mustConfig(ctx)
+		*days = c.HistoryDays
+	}
+	now := time.Now()
+	var cutoff time.Time
+	if *days > 0 {
+		cutoff = now.AddDate(0, 0, -*days)
+	}
+	n, err := prune(batteries, cutoff, now)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Removed %d entries.\n", n)
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// hpbios holds the BIOS settings exposed by hp-bioscfg. HP laptops do not
+// expose a charging threshold but manage charging in firmware, through
+// the settings in hpCharging.
+var hpbios = filepath.Join("/", "sys", "class", "firmware-attributes", "hp-bioscfg", "attributes")
+
+// errUnknownSetting is returned for settings or values the firmware does
+// not offer.
+var errUnknownSetting = errors.New("unknown setting")
+
+// hpCharging maps the names used on the command line to the BIOS settings
+// that control charging.
+var hpCharging = [...][2]string{
+	{"health-manager", "Battery Health Manager"},
+	{"adaptive-optimizer", "Adaptive Battery Optimizer"},
+}
+
+// HPSetting is a charging setting of the HP firmware.
+type HPSetting struct {
+	Key, Name string
+	Current   string
+	// Possible are the values the firmware accepts.
+	Possible []string
+}
+
+// hpDir returns the directory of the BIOS setting. hp-bioscfg names them
+// after the setting, though some firmware uses underscores in place of
+// spaces.
+func hpDir(name string) (string, bool) {
+	for _, dir := range [...]string{name, strings.ReplaceAll(name, " ", "_")} {
+		path := filepath.Join(hpbios, dir)
+		if _, err := sysfs.Stat(path); err == nil {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// hpSettings returns the charging settings the firmware exposes, if any.
+func hpSettings() ([]HPSetting, error) {
+	settings := make([]HPSetting, 0)
+	for _, c := range hpCharging {
+		dir, ok := hpDir(c[1])
+		if !ok {
+			continue
+		}
+		current, err := readString(filepath.Join(dir, "current_value"))
+		if err != nil {
+			return nil, err
+		}
+		s := HPSetting{Key: c[0], Name: c[1], Current: current}
+		possible, err := readString(filepath.Join(dir, "possible_values"))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		for _, v := range strings.Split(possible, ";") {
+			if v = strings.TrimSpace(v); v != "" {
+				s.Possible = append(s.Possible, v)
+			}
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// setHP changes the charging setting with the key to value. Only the values
+// the firmware lists as possible are written, since others may be taken
+// without being applied. The change takes effect after a restart.
+func setHP(key, value string) error {
+	settings, err := hpSettings()
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		if s.Key != key {
+			continue
+		}
+		i := slices.IndexFunc(s.Possible, func(v string) bool { return strings.EqualFold(v, value) })
+		if i < 0 {
+			return fmt.Errorf("%s: %q: %w", s.Name, value, errUnknownSetting)
+		}
+		dir, _ := hpDir(s.Name)
+		return sysfs.WriteFile(filepath.Join(dir, "current_value"), []byte(s.Possible[i]))
+	}
+	return fmt.Errorf("%s: %w", key, errUnknownSetting)
+}
+
+// cmdHPCharging prints or changes the charging settings of the HP BIOS.
+func cmdHPCharging(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	switch len(args) {
+	case 0:
+		settings, err := hpSettings()
+		if err != nil {
+			panic(err)
+		}
+		if len(settings) == 0 {
+			return userError("HP charging settings not found. These require the `hp_bioscfg` module.")
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, s := range settings {
+			fmt.Fprintf(tw, "%s\t%s\t(%s)\n", s.Key, s.Current, strings.Join(s.Possible, ", "))
+		}
+		tw.Flush()
+	case 2:
+		err := setHP(args[0], args[1])
+		if errors.Is(err, errUnknownSetting) {
+			return userError("The firmware does not offer this setting or value. Run `bat hp-charging` to list them.")
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("Setting changed. It takes effect after a restart.")
+	default:
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// inputLimit caps the current drawn from an external supply, in µA.
+	// Chargers that can lower it, such as those behind some USB-C ports,
+	// make it writable, while UCSI supplies only report the negotiated
+	// current_max.
+	inputLimit = "input_current_limit"
+	// minInput is the lowest input limit bat sets, in mA: the current any
+	// USB 2.0 port supplies. Lower limits may leave the machine draining
+	// the battery while plugged in.
+	minInput = 500
+)
+
+var (
+	errNoInputLimit = errors.New("no adjustable input current limit")
+	errInputRange   = errors.New("input current limit out of range")
+)
+
+// Input is the current available from an external supply and the limit on
+// what the charger draws from it, in mA, or 0 if unknown.
+type Input struct {
+	Source
+	Max, Limit int
+	// Writable reports whether the limit can be changed.
+	Writable bool
+}
+
+// inputs returns the current limits of the external power supplies.
+func inputs() ([]Input, error) {
+	srcs, err := sources()
+	if err != nil {
+		return nil, err
+	}
+	inputs := make([]Input, 0, len(srcs))
+	for _, s := range srcs {
+		b := &battery{root: filepath.Join(supplies, s.Name)}
+		in := Input{Source: s}
+		for _, v := range [...]struct {
+			variable string
+			ma       *int
+		}{{"current_max", &in.Max}, {inputLimit, &in.Limit}} {
+			ua, err := b.readInt(v.variable)
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				var numError *strconv.NumError
+				if errors.As(err, &numError) {
+					continue
+				}
+				return nil, err
+			}
+			*v.ma = ua / 1000
+		}
+		if info, err := sysfs.Stat(b.path(inputLimit)); err == nil {
+			in.Writable = info.Mode().Perm()&0o200 != 0
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs, nil
+}
+
+// setInputLimit limits the current drawn from the named supply, or from
+// every supply with an adjustable limit if name is empty, to ma. A limit of
+// 0 lifts it to the current the supply offers. The limit must be at least
+// minInput and no more than what the supply offers, where known.
+func setInputLimit(name string, ma int) error {
+	ins, err := inputs()
+	if err != nil {
+		return err
+	}
+	// Check every supply before changing any.
+	targets := make(map[string]int)
+	for _, in := range ins {
+		if !in.Writable || (name != "" && in.Name != name) {
+			continue
+		}
+		v := ma
+		if v == 0 {
+			v = in.Max
+		}
+		if v < minInput || (in.Max != 0 && v > in.Max) {
+			return errInputRange
+		}
+		targets[in.Name] = v
+	}
+	if len(targets) == 0 {
+		return errNoInputLimit
+	}
+	for name, v := range targets {
+		b := &battery{root: filepath.Join(supplies, name)}
+		if err := b.set(inputLimit, v*1000); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cmdInputLimit prints or sets the input current limit of the charger.
+func cmdInputLimit(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("input-limit", flag.ExitOnError)
+	source := flags.String("source", "", ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	switch flags.NArg() {
+	case 0:
+		ins, err := inputs()
+		if err != nil {
+			panic(err)
+		}
+		if len(ins) == 0 {
+			return userError("No external power supplies found.")
+		}
+		t := Table{Header: []string{"SOURCE", "CLASS", "ONLINE", "MAXIMUM", "LIMIT"}, Right: []int{3, 4}}
+		ma := func(v int) string {
+			if v == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%d mA", v)
+		}
+		for _, in := range ins {
+			limit := ma(in.Limit)
+			if in.Limit != 0 && !in.Writable {
+				limit += " (fixed)"
+			}
+			t.Append(in.Name, in.Class(), strconv.FormatBool(in.Online), ma(in.Max), limit)
+		}
+		if err := t.Render(os.Stdout); err != nil {
+			panic(err)
+		}
+	case 1:
+		v := 0
+		if flags.Arg(0) != "max" {
+			var err error
+			if v, err = strconv.Atoi(flags.Arg(0)); err != nil || v <= 0 {
+				return userError("The limit should be a number of milliamperes or `max`.")
+			}
+		}
+		err := setInputLimit(*source, v)
+		switch {
+		case errors.Is(err, errNoInputLimit):
+			return userError("No power supply with an adjustable input current limit found. Most\n" +
+				"chargers only report the current they negotiated.")
+		case errors.Is(err, errInputRange):
+			return userError(fmt.Sprintf("The limit should be at least %d mA and no more than the supply offers.", minInput))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("Input current limit set. Chargers may reset it when the supply is reconnected.")
+	default:
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	errRunning    = errors.New("daemon already running")
+	errNotStopped = errors.New("running daemon did not stop")
+)
+
+// takeover is how long a daemon replaced with --replace has to exit.
+const takeover = 10 * time.Second
+
+// pidFile returns the file that records the daemon serving the socket at
+// path. It sits next to the socket so that a daemon run without
+// permission to write to /run can still use one.
+func pidFile(path string) string {
+	return filepath.Join(filepath.Dir(path), "bat.pid")
+}
+
+// lock takes the lock on the pid file f without waiting for it.
+func lock(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+}
+
+// terminate asks the daemon holding the lock on the pid file f to stop and
+// takes the lock over once it has, returning the pid recorded in f.
+func terminate(ctx context.Context, f *os.File) (int, error) {
+	pid := 0
+	if contents, err := os.ReadFile(f.Name()); err == nil {
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(contents)))
+	}
+	if pid <= 0 {
+		return 0, errRunning
+	}
+	// The daemon stops gracefully on SIGTERM, removing its socket.
+	if err := unix.Kill(pid, unix.SIGTERM); err != nil && !errors.Is(err, unix.ESRCH) {
+		return pid, err
+	}
+	deadline := time.Now().Add(takeover)
+	err := lock(f)
+	for ; errors.Is(err, unix.EWOULDBLOCK); err = lock(f) {
+		if time.Now().After(deadline) {
+			return pid, errNotStopped
+		}
+		select {
+		case <-ctx.Done():
+			return pid, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return pid, err
+}
+
+// instance takes the lock on the pid file at path that keeps a single
+// daemon running, since two would fight over the thresholds, and records
+// the pid of this process in it. The lock is held until the returned file
+// is closed or the process exits. If another daemon holds it, its pid is
+// returned with errRunning, unless replace is set, in which case it is
+// asked to stop and the lock taken over once it has.
+func instance(ctx context.Context, path string, replace bool) (*os.File, int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = lock(f)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		if !replace {
+			pid := 0
+			if contents, err := os.ReadFile(path); err == nil {
+				pid, _ = strconv.Atoi(strings.TrimSpace(string(contents)))
+			}
+			f.Close()
+			return nil, pid, errRunning
+		}
+		var pid int
+		if pid, err = terminate(ctx, f); err != nil {
+			f.Close()
+			return nil, pid, err
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, 0, nil
+}
+
+// stopDaemon stops the daemon recorded in the pid file at path, if one is
+// running, and returns its pid, or 0 if none was, along with the systemd
+// service it was run by, if any. Such a daemon is stopped through systemd,
+// which would otherwise restart it.
+func stopDaemon(ctx context.Context, path string) (int, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, "", nil
+		}
+		return 0, "", err
+	}
+	// The lock is released when the file is closed.
+	defer f.Close()
+	if err := lock(f); !errors.Is(err, unix.EWOULDBLOCK) {
+		return 0, "", err
+	}
+	pid := 0
+	if contents, err := os.ReadFile(path); err == nil {
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(contents)))
+	}
+	if unit, ok := service(pid); ok {
+		_, err := systemctl(ctx, "stop", unit)
+		return pid, unit, err
+	}
+	pid, err = terminate(ctx, f)
+	return pid, "", err
+}
+
+// service returns the system service running the process pid, or false if
+// it was started otherwise. The unit is the last element of the path of
+// its control group, in the unified hierarchy or the systemd one of the
+// legacy hierarchy.
+func service(pid int) (string, bool) {
+	if pid <= 0 {
+		return "", false
+	}
+	contents, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 || (fields[1] != "" && fields[1] != "name=systemd") {
+			continue
+		}
+		// Services of a user manager cannot be stopped from here.
+		if strings.HasPrefix(fields[2], "/system.slice/") && strings.HasSuffix(fields[2], ".service") {
+			return filepath.Base(fields[2]), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// line summarises the level, status, estimate and health of the battery
+// in one line for status bars and shell prompts, for example "82% ▲
+// charging to 80% limit, 1h 05m to full, health 91%". Parts that are not
+// available are left out.
+func (d *Device) line(now time.Time) (string, error) {
+	level, err := d.read("capacity")
+	if err != nil {
+		return "", err
+	}
+	raw, err := d.read("status")
+	if err != nil {
+		return "", err
+	}
+	status, err := d.explain()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(level + "%")
+	switch raw {
+	case "Charging":
+		b.WriteString(" ▲")
+	case "Discharging":
+		b.WriteString(" ▼")
+	}
+	if status != "" {
+		b.WriteString(" " + strings.ToLower(status[:1]) + status[1:])
+	}
+
+	e, err := d.estimate()
+	if err != nil {
+		return "", err
+	}
+	if e.Available {
+		b.WriteString(", " + formatDuration(e.Duration, "short", "", now))
+		if raw == "Charging" {
+			b.WriteString(" to full")
+		} else {
+			b.WriteString(" left")
+		}
+	}
+	if d.Capabilities()&HasHealth != 0 {
+		health, err := d.health()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, ", health %d%%", health)
+	}
+	return b.String(), nil
+}
+
+// cmdLine prints the summary of the battery on one line.
+func cmdLine(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	s, err := bat.line(time.Now())
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(s)
+	return nil
+}
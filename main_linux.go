@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/json"
 	"errors"
@@ -10,27 +11,49 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	rtdebug "runtime/debug"
 	"slices"
 	"strconv"
-	"strings"
-	"text/template"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
 
 type Service struct {
-	Event, Path, Shell string
-	Threshold          int
+	Event, Shell string
+	Settings     []Setting
+	// OnAC holds the thresholds, in the form BAT0=80, that Path applies
+	// only on external power after the settings are restored, if set with
+	// `threshold --on-ac-only`.
+	OnAC, Path string
+	// Delay is the number of seconds to wait before restoring the settings
+	// on machines with a resume delay quirk.
+	Delay int
+	// Scheduled is the directory holding a file for each battery charging
+	// fully through full-charge-at, during which nothing is restored.
+	Scheduled string
 }
 
-type Target struct {
-	Unit string `json:"unit"`
+// Setting is a value to be restored to the file at Path: a charging
+// threshold of a single battery, or the BIOS charge mode that thresholds
+// set through the BIOS depend on.
+type Setting struct {
+	Path, Value string
 }
 
-const threshold = "charge_control_end_threshold"
+const (
+	threshold = "charge_control_end_threshold"
+
+	// recommended is the threshold suggested to users who are unsure of
+	// which to pick.
+	recommended = 80
+
+	// ignore is the usage of every flag, since flag.Usage is overridden
+	// to print the help document instead.
+	ignore = ""
+)
 
 var (
 	tag string
@@ -44,6 +67,7 @@ var (
 	}
 
 	services = filepath.Join("/", "etc", "systemd", "system")
+	supplies = filepath.Join("/", "sys", "class", "power_supply")
 
 	//go:embed bat.service
 	unit string
@@ -52,12 +76,81 @@ var (
 	usage string
 )
 
+// Command runs a subcommand with the arguments that follow its name. bat is
+// the battery the command applies to, which is nil for standalone commands.
+type Command func(ctx context.Context, bat *Device, batteries []*Device, args []string) error
+
+var (
+	// standalone holds the commands that run before a battery is chosen,
+	// since they work without one.
+	standalone = map[string]Command{
+		"completion":     cmdCompletion,
+		"peripherals":    cmdPeripherals,
+		"simulate-drain": cmdSimulateDrain,
+	}
+
+	subcommands = map[string]Command{
+		"alarm":          cmdAlarm,
+		"apply":          cmdApply,
+		"benchmark":      cmdBenchmark,
+		"capacity":       cmdCapacity,
+		"config":         cmdConfig,
+		"daemon":         cmdDaemon,
+		"doctor":         cmdDoctor,
+		"events":         cmdEvents,
+		"full-charge-at": cmdFullChargeAt,
+		"health":         cmdHealth,
+		"helper":         cmdHelper,
+		"history":        cmdHistory,
+		"hp-charging":    cmdHPCharging,
+		"id":             cmdID,
+		"input-limit":    cmdInputLimit,
+		"line":           cmdLine,
+		"metrics":        cmdMetrics,
+		"on-ac":          cmdOnAC,
+		"panic-restore":  cmdPanicRestore,
+		"persist":        cmdPersist,
+		"remaining":      cmdRemaining,
+		"reset":          cmdReset,
+		"run":            cmdRun,
+		"source":         cmdSource,
+		"state":          cmdState,
+		"status":         cmdStatus,
+		"temperature":    cmdTemperature,
+		"threshold":      cmdThreshold,
+		"top":            cmdTop,
+		"vacation":       cmdVacation,
+		"verify":         cmdVerify,
+		"voltage":        cmdVoltage,
+		"which":          cmdWhich,
+	}
+)
+
+var (
+	// errUsage is returned by a command given the wrong number of
+	// arguments, and check prints the help document for it.
+	errUsage = errors.New("invalid number of arguments")
+	// errFailed is returned by a command that has already explained why
+	// it failed, and check only sets the exit status for it.
+	errFailed = errors.New("command failed")
+)
+
+// userError is returned by a command with the message to print for it.
+type userError string
+
+func (e userError) Error() string {
+	return string(e)
+}
+
 type battery struct {
 	root string
+	// snap holds the variables read from the uevent file by snapshot, if
+	// taken.
+	snap map[string]string
 }
 
 func (b *battery) has(variable string) (bool, error) {
-	_, err := os.Stat(b.path(variable))
+	_, err := sysfs.Stat(b.path(variable))
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return false, nil
@@ -67,40 +160,188 @@ func (b *battery) has(variable string) (bool, error) {
 	return true, nil
 }
 
+// health returns the eroded capacity as a percentage of the capacity when
+// the battery was new.
+func (b *battery) health() (int, error) {
+	full, design, err := b.full()
+	if err != nil {
+		return 0, err
+	}
+	return full * 100 / design, nil
+}
+
+// full returns the current and design full charge of the battery.
+func (b *battery) full() (int, int, error) {
+	// Some devices use charge_* and others energy_* so probe both. Should
+	// have one or the other.
+	prefix := "charge"
+	ok, err := b.has("charge_full")
+	if err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		prefix = "energy"
+	}
+	full, err := b.readInt(prefix + "_full")
+	if err != nil {
+		return 0, 0, err
+	}
+	design, err := b.readInt(prefix + "_full_design")
+	if err != nil {
+		return 0, 0, err
+	}
+	return full, design, nil
+}
+
+// controls are the variables that some drivers attach to the parent
+// device of the battery instead of the battery itself. On Dell laptops the
+// thresholds may only be found among the BIOS settings, and on machines
+// with a control quirk in the file it names.
+var controls = [...]string{threshold, startThreshold, "charge_behaviour"}
+
 func (b *battery) path(variable string) string {
-	return filepath.Join(b.root, variable)
+	path := filepath.Join(b.root, variable)
+	for _, control := range controls {
+		if variable != control {
+			continue
+		}
+		if _, err := sysfs.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			parent := filepath.Join(b.root, "device", variable)
+			if _, err := sysfs.Stat(parent); err == nil {
+				return parent
+			}
+			if c := machine().Control; variable == threshold && c != "" {
+				if !filepath.IsAbs(c) {
+					c = filepath.Join(b.root, c)
+				}
+				if _, err := sysfs.Stat(c); err == nil {
+					return c
+				}
+			}
+			if dell, ok := dellPath(variable); ok {
+				if _, err := sysfs.Stat(dell); err == nil {
+					return dell
+				}
+			}
+		}
+	}
+	return path
 }
 
 func (b *battery) read(variable string) (string, error) {
-	contents, err := os.ReadFile(b.path(variable))
+	if v, ok := b.snap[variable]; ok {
+		return v, nil
+	}
+	return readString(b.path(variable))
+}
+
+// readInt reads a variable holding an integer, which is how sysfs
+// reports levels, thresholds and readings in micro units.
+func (b *battery) readInt(variable string) (int, error) {
+	v, err := b.read(variable)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// readString reads a sysfs file holding a single value, such as a
+// variable of a supply, a DMI field or a BIOS setting.
+func readString(path string) (string, error) {
+	contents, err := sysfs.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
 	return string(bytes.TrimSpace(contents)), nil
 }
 
+// readInt reads a sysfs file holding an integer.
+func readInt(path string) (int, error) {
+	v, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// writeInt writes the value to a sysfs file and reads it back, returning
+// errNotApplied if the driver ignored or rounded it.
+func writeInt(path string, value int) error {
+	if err := sysfs.WriteFile(path, []byte(strconv.Itoa(value))); err != nil {
+		return err
+	}
+	v, err := readString(path)
+	if err != nil {
+		return err
+	}
+	if v != strconv.Itoa(value) {
+		return fmt.Errorf("%s is %s rather than %d: %w", path, v, value, errNotApplied)
+	}
+	return nil
+}
+
 func (b *battery) write(variable string, contents []byte) error {
-	return os.WriteFile(b.path(variable), contents, 0o644)
+	delete(b.snap, variable)
+	path := b.path(variable)
+	if isDell(path) {
+		if err := customCharge(); err != nil {
+			return err
+		}
+	}
+	return sysfs.WriteFile(path, contents)
 }
 
 func main() {
-	// Default flag.Usage is overridden below.
-	const ignore = ""
 	var (
 		d, debug   = flag.Bool("d", false, ignore), flag.Bool("debug", false, ignore)
 		h, help    = flag.Bool("h", false, ignore), flag.Bool("help", false, ignore)
 		v, version = flag.Bool("v", false, ignore), flag.Bool("version", false, ignore)
+		o, output  = flag.String("o", "", ignore), flag.String("output", "", ignore)
+		appending  = flag.Bool("append", false, ignore)
+		wait       = flag.Duration("wait-for-device", 0, ignore)
+		asJSON     = flag.Bool("json", false, ignore)
 	)
+	flag.BoolVar(&strict, "strict", false, ignore)
+	flag.StringVar(&configFile, "config", "", ignore)
 	flag.Usage = func() {
-		fmt.Print(usage)
+		fmt.Print(localUsage())
 	}
 	flag.Parse()
 
+	// Output is collected and only written to the file once the command
+	// succeeds. This is deferred before the panic handler, which exits
+	// before it runs if the command fails.
+	path := *output
+	if *o != "" {
+		path = *o
+	}
+	if path != "" {
+		tmp, err := redirect(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write to %s: %v.\n", path, err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := commit(tmp, path, *appending); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not write to %s: %v.\n", path, err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	if *h || *help {
 		flag.Usage()
 		return
 	}
 
+	if (*v || *version) && *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(build()); err != nil {
+			panic(err)
+		}
+		return
+	}
 	if *v || *version {
 		fmt.Printf("bat %s\nCopyright (c) 2021 Tshaka Lekholoane.\nMIT Licence.\n", tag)
 		return
@@ -109,25 +350,81 @@ func main() {
 	defer func() {
 		if err := recover(); err != nil {
 			var message string
-			if *d || *debug {
+			switch {
+			case os.Getenv("BAT_CRASH_REPORT") != "":
+				path, rerr := writeCrashReport(err, rtdebug.Stack())
+				if rerr != nil {
+					message = fmt.Sprintf("%s\n\n%s\nCould not write a crash report: %v.", err, string(rtdebug.Stack()), rerr)
+					break
+				}
+				message = fmt.Sprintf("A fatal error occurred. A crash report has been written to %s.\n"+
+					"Please review it and attach it to an issue filed at the following address:\n"+
+					"https://github.com/tshakalekholoane/bat/issues/new.", path)
+			case *d || *debug:
 				message = fmt.Sprintf("%s\n\n%s", err, string(rtdebug.Stack()))
-			} else {
-				message = "A fatal error occurred. Please rerun the command with the `--debug` flag\n" +
-					"enabled, and file an issue with the resulting output to the following address:\n" +
-					"https://github.com/tshakalekholoane/bat/issues/new."
+			default:
+				message = "A fatal error occurred. Please rerun the command with the BAT_CRASH_REPORT\n" +
+					"environment variable set to 1, and file an issue with the resulting report to\n" +
+					"the following address: https://github.com/tshakalekholoane/bat/issues/new."
 			}
 			fmt.Fprintln(os.Stderr, message)
+			// Scripts such as package hooks rely on the exit status alone.
+			os.Exit(2)
 		}
 	}()
 
+	// Allows pointing bat at a fake power_supply tree, such as the one
+	// created by simulate-drain, during development.
+	if root := os.Getenv("BAT_SUPPLIES"); root != "" {
+		supplies = root
+	}
+
+	// Cancels external commands such as systemctl on an interrupt so that
+	// a hung invocation does not leave the program waiting indefinitely.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, unix.SIGTERM)
+	defer stop()
+
+	var (
+		batteries []*Device
+		err       error
+	)
+	if numbers, err = localNumbers(); err != nil {
+		fmt.Fprintln(os.Stderr, "BAT_UNITS should be one of `si` or `milli`.")
+		os.Exit(1)
+	}
+	if *wait > 0 {
+		batteries, err = waitForDevice(ctx, os.Getenv("BAT_DEVICE"), *wait)
+		check(ctx, err)
+	} else {
+		batteries, err = devices()
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	if flag.NArg() == 0 {
+		// Walk first-time users through the setup instead of printing
+		// the help document.
+		if len(batteries) > 0 && interactive() && !persisted(defaultUnits) {
+			onboard(ctx, batteries[0], batteries)
+			return
+		}
 		flag.Usage()
 		os.Exit(2)
 	}
-
-	batteries, err := filepath.Glob(filepath.Join("/", "sys", "class", "power_supply", "BAT?"))
-	if err != nil {
-		panic(err)
+	// Completion works without a battery so that scripts can be generated
+	// when packaging.
+	if flag.Arg(0) == "__complete" {
+		for _, c := range complete(batteries, flag.Args()[1:]) {
+			fmt.Println(c)
+		}
+		return
+	}
+	// Some commands work without a battery, such as listing peripherals on
+	// a desktop with a wireless mouse.
+	if cmd, ok := standalone[flag.Arg(0)]; ok {
+		check(ctx, cmd(ctx, nil, batteries, flag.Args()[1:]))
+		return
 	}
 	if len(batteries) == 0 {
 		fmt.Fprintln(
@@ -137,243 +434,163 @@ func main() {
 		)
 		os.Exit(1)
 	}
-	// Default to using the first battery.
-	bat := &battery{root: batteries[0]}
-
-	switch subcommand := flag.Arg(0); subcommand {
-	case "capacity", "status":
-		v, err := bat.read(subcommand)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println(v)
-	case "health":
-		// Some devices use charge_* and others energy_* so probe both. The
-		// health is computed as x / y where x is the eroded capacity and y
-		// is the capacity when the battery was new.
-		var (
-			err  error
-			v, w string
-		)
-		s, t := "charge_full", "charge_full_design"
-		v, err = bat.read(s)
-		if err != nil {
-			if errors.Is(err, fs.ErrNotExist) {
-				goto energy
-			}
-			panic(err)
-		}
-		w, err = bat.read(t)
-		if err != nil && !errors.Is(err, fs.ErrNotExist) {
-			panic(err)
-		}
-		goto health
-	energy:
-		// Should have one or the other.
-		s, t = "energy_full", "energy_full_design"
-		v, err = bat.read(s)
-		if err != nil {
-			panic(err)
-		}
-		w, err = bat.read(t)
-		if err != nil {
-			panic(err)
-		}
-	health:
-		x, err := strconv.Atoi(v)
-		if err != nil {
-			panic(err)
-		}
-		y, err := strconv.Atoi(w)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println(x * 100 / y)
-	case "persist":
-		ok, err := bat.has(threshold)
-		if err != nil {
-			panic(err)
+	// Default to using the first battery that is present, unless another
+	// is named in the environment.
+	bat := batteries[0]
+	for _, d := range batteries {
+		if d.present() {
+			bat = d
+			break
 		}
+	}
+	if name := os.Getenv("BAT_DEVICE"); name != "" {
+		d, ok := device(batteries, name)
 		if !ok {
-			fmt.Fprintln(os.Stderr, "Charging threshold setting not found.")
-			os.Exit(1)
-		}
-
-		// systemd 244-rc1 is the earliest version to allow restarts for
-		// oneshot services.
-		output, err := exec.Command("systemctl", "--version").CombinedOutput()
-		if err != nil {
-			panic(err)
-		}
-		var revision int
-		_, err = fmt.Sscanf(string(output), "systemd %d", &revision)
-		if err != nil {
-			panic(err)
-		}
-		if revision < 244 {
-			fmt.Fprintln(os.Stderr, "Requires systemd version 243-rc1 or later.")
+			fmt.Fprintf(os.Stderr, "There is no `%s` battery.\n", name)
 			os.Exit(1)
 		}
-
-		shell, err := exec.LookPath("sh")
-		if err != nil {
-			if errors.Is(err, exec.ErrNotFound) {
-				fmt.Fprintln(os.Stderr, "Could not find `sh` in your `$PATH`.")
-				os.Exit(1)
-			}
-			panic(err)
-		}
-
-		v, err := bat.read(threshold)
-		if err != nil {
-			panic(err)
-		}
-		current, err := strconv.Atoi(v)
-		if err != nil {
-			panic(err)
-		}
-
-		// Creates services for events with defined targets (targets vary by
-		// distribution).
-		cmd := exec.Command("systemctl", "list-units", "--type", "target", "--all", "--plain", "--output", "json")
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			panic(err)
-		}
-		targets := make([]Target, 0)
-		if err = json.Unmarshal(output, &targets); err != nil {
-			panic(err)
-		}
-		available := make([]string, 0)
-		for _, target := range targets {
-			event := strings.TrimSuffix(target.Unit, ".target")
-			if slices.Index(events[:], event) != -1 {
-				available = append(available, event)
-			}
-		}
-		tmpl := template.Must(template.New("unit").Parse(unit))
-		for _, event := range available {
-			service := "bat-" + event + ".service"
-			f, err := os.Create(filepath.Join(services, service))
-			if err != nil {
-				if errors.Is(err, unix.EACCES) {
-					fmt.Fprintln(os.Stderr, "Permission denied. Try running this command with `sudo`.")
-					os.Exit(1)
-				}
-				panic(err)
-			}
-			s := Service{
-				Event:     event,
-				Path:      bat.path(threshold),
-				Shell:     shell,
-				Threshold: current,
-			}
-			if err = tmpl.Execute(f, s); err != nil {
-				panic(err)
-			}
-			if err := exec.Command("systemctl", "enable", service).Run(); err != nil {
+		bat = d
+	}
+	if !bat.present() && flag.Arg(0) != "daemon" {
+		fmt.Fprintf(os.Stderr, "%s has been removed.\n", bat.Name)
+		os.Exit(1)
+	}
+	if legacyDevice(bat) {
+		degraded("legacy-acpi")
+	}
+	// Commands that read the batteries once do so from a single snapshot
+	// rather than opening a file per variable.
+	if slices.Contains(snapshotted[:], flag.Arg(0)) {
+		for _, d := range batteries {
+			if err := d.snapshot(); err != nil {
 				panic(err)
 			}
-			f.Close()
 		}
-		fmt.Println("Persistence of the current charging threshold enabled.")
-	case "threshold":
-		ok, err := bat.has(threshold)
-		if err != nil {
-			panic(err)
-		}
-		if !ok {
-			fmt.Fprintln(os.Stderr, "Charging threshold setting not found.")
-			os.Exit(1)
-		}
-		switch flag.NArg() {
-		case 1:
-			// Get.
-			var v string
-			v, err = bat.read(threshold)
-			if err != nil {
-				panic(err)
-			}
-			fmt.Println(v)
-		case 2:
-			// Set.
-			// The earliest version of the Linux kernel to expose the battery
-			// charging threshold is 5.4.
-			var utsname unix.Utsname
-			if err = unix.Uname(&utsname); err != nil {
-				panic(err)
-			}
-			var maj, min int
-			_, err = fmt.Sscanf(string(utsname.Release[:]), "%d.%d", &maj, &min)
-			if err != nil {
-				panic(err)
-			}
-			if maj <= 5 && (maj != 5 || min < 4) {
-				fmt.Fprintln(os.Stderr, "Requires Linux kernel version 5.4 or later.")
-				os.Exit(1)
-			}
+	}
 
-			setting := flag.Arg(1)
-			i, err := strconv.Atoi(setting)
-			if err != nil {
-				if errors.Is(err, strconv.ErrSyntax) {
-					fmt.Fprintln(os.Stderr, "Argument should be an integer.")
-					os.Exit(1)
-				}
-				panic(err)
-			}
-			if i < 1 || i > 100 {
-				fmt.Fprintln(os.Stderr, "Threshold value should be between 1 and 100.")
-				os.Exit(1)
-			}
-			if err := bat.write(threshold, []byte(setting)); err != nil {
-				if errors.Is(err, unix.EACCES) {
-					fmt.Fprintln(os.Stderr, "Permission denied. Try running this command with `sudo`.")
-					os.Exit(1)
-				}
-				panic(err)
-			}
-			fmt.Println("Charging threshold set.\n" +
-				"Run `sudo bat persist` to persist the setting between restarts.")
-		default:
-			fmt.Fprintln(os.Stderr, "Invalid number of arguments.")
-			flag.Usage()
-			os.Exit(1)
-		}
-	case "reset":
-		for _, event := range events {
-			service := "bat-" + event + ".service"
-			output, err := exec.Command("systemctl", "disable", service).CombinedOutput()
-			if err != nil {
-				// WORKAROUND: systemd returns the generic exit code 1 for all
-				// failures, so triage using a substring search on the output.
-				// This method may be unreliable in non-EN locales.
-				switch {
-				case bytes.Contains(output, []byte("authentication required")):
-					fmt.Fprintln(os.Stderr, "Permission denied. Try running this command with `sudo`.")
-					os.Exit(1)
-				case bytes.Contains(output, []byte("service does not exist")):
-					continue
-				default:
-					panic(string(output))
-				}
-			}
-			err = os.Remove(filepath.Join(services, service))
-			if err != nil && !errors.Is(err, unix.ENOENT) {
-				if errors.Is(err, unix.EACCES) {
-					fmt.Fprintln(os.Stderr, "Permission denied. Try running this command with `sudo`.")
-					os.Exit(1)
-				}
-				panic(err)
-			}
-		}
-		fmt.Println("Charging threshold persistence reset.")
-	default:
+	cmd, ok := subcommands[flag.Arg(0)]
+	if !ok {
 		fmt.Fprintf(
 			os.Stderr,
 			"There is no `%s` command. Run `bat --help` to see a list of available commands.\n",
-			subcommand,
+			flag.Arg(0),
 		)
 		os.Exit(1)
 	}
+	check(ctx, cmd(ctx, bat, batteries, flag.Args()[1:]))
+}
+
+// audit records a threshold change made from the command line. The change
+// has been made by then, so failing to record it is only a warning.
+func audit(name string, value int) {
+	if err := recordChange(name, invoker(), value, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not record the change: %v.\n", err)
+		degraded("change-not-recorded")
+	}
+}
+
+// mustConfig returns the configuration, with the environment variables
+// applied, along with the file or variable that set each key. It exits
+// with a message if either is malformed. The configuration is optional, so
+// the defaults are returned if there is none.
+func mustConfig(ctx context.Context) (Config, map[string]string) {
+	c, sources, err := findConfig()
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		err = c.override(sources)
+	}
+	check(ctx, err)
+	return c, sources
+}
+
+// historyRange returns the range given by --since, --until and --last to
+// a history view, or the message to print if they are malformed.
+func historyRange(since, until, last string) (Range, error) {
+	if since != "" && last != "" {
+		return Range{}, userError("The --since flag cannot be combined with --last.")
+	}
+	r, err := parseRange(since, until, last, time.Now())
+	if errors.Is(err, errRange) {
+		return Range{}, userError("The range should end after it starts.")
+	}
+	if err != nil {
+		return Range{}, userError("Times should be of the form 2024-01-01, 2024-01-01T06:00, today,\n" +
+			"yesterday, or a duration ago such as 48h or 7d.")
+	}
+	return r, nil
+}
+
+// updatePersisted rewrites the persistence units, if installed, after the
+// thresholds have been changed so that they do not restore stale ones. It
+// reports whether persistence was enabled. Users setting the threshold
+// through the helper cannot rewrite the units so they are told to.
+func updatePersisted(ctx context.Context, batteries []*Device) bool {
+	ok, outcomes, err := repersist(ctx, defaultUnits, batteries)
+	if errors.Is(err, unix.EACCES) || errors.Is(err, fs.ErrPermission) {
+		if ok {
+			fmt.Println("Run `sudo bat persist` to update the persisted setting.")
+		} else {
+			fmt.Println("Run `sudo bat threshold --verify-after-resume` to update the verified setting.")
+		}
+		degraded("persistence-not-updated")
+		return ok
+	}
+	for _, outcome := range outcomes {
+		if outcome.Action != "unchanged" {
+			report([]Outcome{outcome})
+		}
+	}
+	check(ctx, err)
+	if ok {
+		fmt.Println("Persisted setting updated.")
+	}
+	return ok
+}
+
+// check exits with a message for the errors that are expected to be
+// caused by the environment rather than a bug, and panics otherwise.
+func check(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	var (
+		message string
+		user    userError
+	)
+	switch {
+	case errors.Is(err, errFailed):
+		os.Exit(1)
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(os.Stderr, "Invalid number of arguments.")
+		flag.Usage()
+		os.Exit(1)
+	case errors.As(err, &user):
+		message = string(user)
+	case ctx.Err() != nil:
+		message = "Interrupted."
+	case errors.Is(err, errReadOnly):
+		message = readOnlyMessage
+	case errors.Is(err, errConfig):
+		message = fmt.Sprintf("%v.", err)
+	case errors.Is(err, errInvalidUnit):
+		message = fmt.Sprintf("Generated an invalid unit, which was not installed: %v.\n"+
+			"Please report this at https://github.com/tshakalekholoane/bat/issues.", err)
+	case errors.Is(err, unix.EACCES):
+		message = "Permission denied. Try running this command with `sudo`."
+	case errors.Is(err, errNoThreshold):
+		message = missing(nil)
+	case errors.Is(err, errSystemdVersion):
+		message = "Requires systemd version 243-rc1 or later."
+	case errors.Is(err, errNoShell):
+		message = "Could not find `sh` in your `$PATH`."
+	case errors.Is(err, errNoSysext):
+		message = "Could not find `systemd-sysext` in your `$PATH`."
+	case errors.Is(err, errNotApplied):
+		message = "The device did not apply the setting. It may only accept certain values."
+	case errors.Is(err, unix.EINVAL):
+		message = "The device rejected the setting. It may only accept certain values."
+	default:
+		panic(err)
+	}
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
 }
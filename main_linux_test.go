@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeFS is a sysfs held in memory, with paths rooted at /. Writes to a
+// path in errs fail with its error, as drivers reject them, and writes are
+// dropped altogether if ignore is set, as some drivers do.
+type fakeFS struct {
+	files  fstest.MapFS
+	errs   map[string]error
+	ignore bool
+}
+
+func (f *fakeFS) Glob(pattern string) ([]string, error) {
+	matches, err := fs.Glob(f.files, strings.TrimPrefix(pattern, "/"))
+	for i := range matches {
+		matches[i] = "/" + matches[i]
+	}
+	return matches, err
+}
+
+func (f *fakeFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.files, strings.TrimPrefix(name, "/"))
+}
+
+func (f *fakeFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(f.files, strings.TrimPrefix(name, "/"))
+}
+
+func (f *fakeFS) WriteFile(name string, data []byte) error {
+	if err, ok := f.errs[name]; ok {
+		return &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	file, ok := f.files[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !f.ignore {
+		file.Data = data
+	}
+	return nil
+}
+
+// useFS substitutes f for sysfs until the end of the test.
+func useFS(t *testing.T, f FS) {
+	t.Helper()
+	saved := sysfs
+	sysfs = f
+	t.Cleanup(func() { sysfs = saved })
+}
+
+// newFakeBattery returns a battery at /sys/class/power_supply/BAT0 with
+// the given variables.
+func newFakeBattery(variables map[string]string) (*fakeFS, *battery) {
+	root := "/sys/class/power_supply/BAT0"
+	f := &fakeFS{files: fstest.MapFS{}}
+	for name, v := range variables {
+		f.files[strings.TrimPrefix(root, "/")+"/"+name] = &fstest.MapFile{Data: []byte(v + "\n")}
+	}
+	return f, &battery{root: root}
+}
+
+func TestSet(t *testing.T) {
+	path := "/sys/class/power_supply/BAT0/" + threshold
+	tests := []struct {
+		name   string
+		errs   map[string]error
+		ignore bool
+		want   error
+	}{
+		{name: "applied"},
+		{name: "permission denied", errs: map[string]error{path: syscall.EACCES}, want: fs.ErrPermission},
+		{name: "rejected", errs: map[string]error{path: syscall.EINVAL}, want: syscall.EINVAL},
+		{name: "ignored", ignore: true, want: errNotApplied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, b := newFakeBattery(map[string]string{threshold: "100"})
+			f.errs, f.ignore = tt.errs, tt.ignore
+			useFS(t, f)
+			err := b.set(threshold, 80)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("set(80) = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if v, err := b.readInt(threshold); err != nil || v != 80 {
+				t.Errorf("threshold after set(80) = %d, %v, want 80", v, err)
+			}
+		})
+	}
+}
+
+func TestSetMissing(t *testing.T) {
+	f, b := newFakeBattery(map[string]string{})
+	useFS(t, f)
+	if err := b.set(threshold, 80); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("set(80) = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestWriteInt(t *testing.T) {
+	path := filepath.Join(sysman, dellSettings[threshold], "current_value")
+	tests := []struct {
+		name   string
+		ignore bool
+		want   error
+	}{
+		{name: "applied"},
+		{name: "ignored", ignore: true, want: errNotApplied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeFS{files: fstest.MapFS{strings.TrimPrefix(path, "/"): {Data: []byte("100\n")}}, ignore: tt.ignore}
+			useFS(t, f)
+			if err := writeInt(path, 80); !errors.Is(err, tt.want) {
+				t.Fatalf("writeInt(80) = %v, want %v", err, tt.want)
+			}
+			if v, err := readInt(path); err != nil || (tt.want == nil && v != 80) {
+				t.Errorf("readInt after writeInt(80) = %d, %v", v, err)
+			}
+		})
+	}
+}
+
+func TestReadMissing(t *testing.T) {
+	f, b := newFakeBattery(map[string]string{"capacity": "82"})
+	useFS(t, f)
+	if _, err := b.read("health"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("read(health) = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := b.readInt(threshold); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("readInt(%s) = %v, want %v", threshold, err, fs.ErrNotExist)
+	}
+	if ok, err := b.has(threshold); ok || err != nil {
+		t.Errorf("has(%s) = %t, %v, want false, nil", threshold, ok, err)
+	}
+}
+
+func TestAlarm(t *testing.T) {
+	tests := []struct {
+		name      string
+		variables map[string]string
+		want      int
+		wantErr   error
+	}{
+		{name: "charge", variables: map[string]string{"alarm": "400000", "charge_full": "4000000"}, want: 10},
+		{name: "energy", variables: map[string]string{"alarm": "2500000", "energy_full": "50000000"}, want: 5},
+		// Some firmware reports no capacity while calibrating.
+		{name: "zero capacity", variables: map[string]string{"alarm": "400000", "charge_full": "0"}, wantErr: errNoCapacity},
+		{name: "no capacity", variables: map[string]string{"alarm": "400000"}, wantErr: errNoCapacity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, b := newFakeBattery(tt.variables)
+			useFS(t, f)
+			got, err := b.alarm()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("alarm = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("alarm = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end int
+		want       error
+	}{
+		{name: "lowered", start: 40, end: 80},
+		{name: "raised", start: 95, end: 100},
+		{name: "inverted", start: 80, end: 40, want: errThresholdOrder},
+		{name: "equal", start: 80, end: 80, want: errThresholdOrder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, b := newFakeBattery(map[string]string{startThreshold: "0", threshold: "90"})
+			useFS(t, f)
+			err := b.setThresholds(tt.start, tt.end)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("setThresholds(%d, %d) = %v, want %v", tt.start, tt.end, err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			start, _ := b.readInt(startThreshold)
+			end, _ := b.readInt(threshold)
+			if start != tt.start || end != tt.end {
+				t.Errorf("thresholds = %d, %d, want %d, %d", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestWriteFileExisting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, threshold)
+	if err := os.WriteFile(path, []byte("100\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (osFS{}).WriteFile(path, []byte("80")); err != nil {
+		t.Fatalf("WriteFile = %v", err)
+	}
+	if contents, _ := os.ReadFile(path); string(contents) != "80" {
+		t.Errorf("contents = %q, want %q", contents, "80")
+	}
+}
+
+func TestWriteFileMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), threshold)
+	if err := (osFS{}).WriteFile(path, []byte("80")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("WriteFile = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("WriteFile created %s", path)
+	}
+}
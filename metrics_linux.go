@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric is a single reading of a battery, exported for monitoring
+// systems.
+type Metric struct {
+	Name, Help string
+	Value      float64
+}
+
+// metrics returns the readings supported by the device.
+func (d *Device) metrics() ([]Metric, error) {
+	ms := make([]Metric, 0)
+	capacity, err := d.readInt("capacity")
+	if err != nil {
+		return nil, err
+	}
+	ms = append(ms, Metric{"capacity_percent", "Battery level.", float64(capacity)})
+	status, err := d.read("status")
+	if err != nil {
+		return nil, err
+	}
+	charging := 0.0
+	if status == "Charging" {
+		charging = 1
+	}
+	ms = append(ms, Metric{"charging", "Whether the battery is charging.", charging})
+	if d.Capabilities()&HasThreshold != 0 {
+		limit, err := d.readInt(threshold)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, Metric{"threshold_percent", "Charging threshold.", float64(limit)})
+	}
+	if d.Capabilities()&HasHealth != 0 {
+		full, design, err := d.full()
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, Metric{"health_percent", "Full charge as a percentage of the design charge.",
+			float64(full) * 100 / float64(design)})
+	}
+	if d.Capabilities()&HasVoltage != 0 {
+		v, err := d.voltages()
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, Metric{"voltage_volts", "Current voltage.", v.Now})
+	}
+	if d.Capabilities()&HasPowerReadings != 0 {
+		watts, _, err := d.drain()
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, Metric{"drain_watts", "Discharge rate, or zero when not discharging.", watts})
+	}
+	if d.Capabilities()&HasTemperature != 0 {
+		temp, err := d.temperature()
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, Metric{"temperature_celsius", "Battery temperature.", temp})
+	}
+	return ms, nil
+}
+
+// metricFormats lists the accepted values of the --format flag.
+var metricFormats = [...]string{"prometheus", "influx"}
+
+// writeMetrics writes the readings of each battery in the Prometheus text
+// exposition format or as InfluxDB line protocol, for node_exporter's
+// textfile collector and Telegraf's exec input respectively.
+func writeMetrics(w io.Writer, batteries []*Device, format string, now time.Time) error {
+	readings := make(map[string][]Metric)
+	names := make([]string, 0, len(batteries))
+	for _, b := range batteries {
+		if !b.present() {
+			continue
+		}
+		ms, err := b.metrics()
+		if err != nil {
+			return err
+		}
+		readings[b.Name] = ms
+		names = append(names, b.Name)
+	}
+
+	if format == "influx" {
+		for _, name := range names {
+			fields := make([]string, len(readings[name]))
+			for i, m := range readings[name] {
+				fields[i] = m.Name + "=" + strconv.FormatFloat(m.Value, 'f', -1, 64)
+			}
+			if _, err := fmt.Fprintf(w, "bat,device=%s %s %d\n", name, strings.Join(fields, ","), now.UnixNano()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Prometheus groups samples by metric, each preceded by its help and
+	// type.
+	seen := make(map[string]bool)
+	for _, first := range names {
+		for _, m := range readings[first] {
+			if seen[m.Name] {
+				continue
+			}
+			seen[m.Name] = true
+			fmt.Fprintf(w, "# HELP bat_%s %s\n# TYPE bat_%[1]s gauge\n", m.Name, m.Help)
+			for _, name := range names {
+				for _, n := range readings[name] {
+					if n.Name == m.Name {
+						fmt.Fprintf(w, "bat_%s{device=%q} %s\n", m.Name, name, strconv.FormatFloat(n.Value, 'f', -1, 64))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cmdMetrics prints the readings of every battery for monitoring systems.
+func cmdMetrics(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("metrics", flag.ExitOnError)
+	format := flags.String("format", "prometheus", ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if !slices.Contains(metricFormats[:], *format) {
+		return userError("Format should be one of `prometheus` or `influx`.")
+	}
+	if err := writeMetrics(os.Stdout, batteries, *format, time.Now()); err != nil {
+		panic(err)
+	}
+	return nil
+}
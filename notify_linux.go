@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// notify sends the state, such as READY=1, to the service manager over
+// the socket named by NOTIFY_SOCKET, as sd_notify does. It does nothing
+// unless the daemon was started by systemd as a Type=notify service.
+func notify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// Names starting with @ are in the abstract namespace, which the net
+	// package handles.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdog returns the interval within which the service manager expects
+// to hear from the daemon when WatchdogSec is set, or zero.
+func watchdog() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Health is the result of the health method: whether the batteries have
+// been read since the daemon started, when they were last read, and
+// whether reading them has been stuck for longer than the interval.
+type Health struct {
+	Ready    bool      `json:"ready"`
+	LastRead time.Time `json:"last_read,omitempty"`
+	Live     bool      `json:"live"`
+}
+
+// health reports the health of the poll loop at now.
+func (d *daemon) health(now time.Time) Health {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return Health{
+		Ready:    !d.read.IsZero(),
+		LastRead: d.read,
+		Live:     d.busy.IsZero() || now.Sub(d.busy) < max(d.interval, d.idle),
+	}
+}
+
+// supervise notifies the service manager that the daemon is alive every
+// half of the watchdog interval, as systemd recommends, for as long as the
+// poll loop is not stuck, until ctx is cancelled. Once the loop is stuck
+// the notifications stop and systemd restarts the daemon.
+func (d *daemon) supervise(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !d.health(now).Live {
+				d.log.Warn("reading batteries stuck", "operation", "watchdog")
+				continue
+			}
+			if err := notify("WATCHDOG=1"); err != nil {
+				d.log.Warn("notifying service manager failed", "operation", "watchdog", "error", err)
+			}
+		}
+	}
+}
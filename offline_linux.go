@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// errNoTargets is returned when none of the targets of the supported
+// events are installed in the tree being persisted to.
+var errNoTargets = errors.New("no supported targets")
+
+// unitPaths are the directories, relative to the root, in which systemd
+// looks for the targets of the events.
+var unitPaths = [...]string{
+	filepath.Join("etc", "systemd", "system"),
+	filepath.Join("usr", "lib", "systemd", "system"),
+	filepath.Join("lib", "systemd", "system"),
+}
+
+// offlineTargets returns the targets of the events installed in the tree
+// at root.
+func offlineTargets(root string) []string {
+	targets := make([]string, 0, len(events))
+	for _, event := range events {
+		for _, dir := range unitPaths {
+			if _, err := os.Stat(filepath.Join(root, dir, event+".target")); err == nil {
+				targets = append(targets, event+".target")
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// persistOffline writes the units into the tree at root and enables them
+// by linking them into the .wants directories of their targets, as
+// systemctl enable --root does, for image builds and chroots where
+// systemctl cannot reach a running systemd. The targets are those
+// installed under root and the shell is assumed to be /bin/sh. Thresholds
+// applied only on external power are left to the udev rule, which refers
+// to the executable outside the tree.
+func persistOffline(root string, units Units, batteries []*Device) ([]Outcome, error) {
+	settings, err := current(batteries)
+	if err != nil {
+		return nil, err
+	}
+	targets := offlineTargets(root)
+	if len(targets) == 0 {
+		return nil, errNoTargets
+	}
+	want, err := render(targets, Service{Shell: "/bin/sh", Settings: settings})
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(root, units.Dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	outcomes := make([]Outcome, 0, len(events))
+	for _, event := range events {
+		service := units.name(event)
+		path := filepath.Join(dir, service)
+		wants := filepath.Join(dir, event+".target.wants")
+		link := filepath.Join(wants, service)
+
+		existing, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return outcomes, err
+		}
+		exists := err == nil
+		_, err = os.Lstat(link)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return outcomes, err
+		}
+		enabled := err == nil
+
+		contents, ok := want[event]
+		if !ok {
+			if !exists && !enabled {
+				continue
+			}
+			for _, p := range [...]string{link, path} {
+				if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return outcomes, err
+				}
+			}
+			outcomes = append(outcomes, Outcome{Unit: service, Action: "removed"})
+			continue
+		}
+
+		action := "unchanged"
+		if !exists || !bytes.Equal(existing, contents) {
+			if err := checkUnit(root, service, contents); err != nil {
+				return outcomes, err
+			}
+			if err := os.WriteFile(path, contents, 0o644); err != nil {
+				os.Remove(path)
+				return outcomes, err
+			}
+			action = "updated"
+			if !exists {
+				action = "created"
+			}
+		}
+		if !enabled {
+			if err := os.MkdirAll(wants, 0o755); err != nil {
+				return outcomes, err
+			}
+			// The link is absolute like those made by systemctl enable, so
+			// that it resolves once the tree is booted.
+			if err := os.Symlink(filepath.Join(units.Dir, service), link); err != nil {
+				return outcomes, err
+			}
+			if action == "unchanged" {
+				action = "enabled"
+			}
+		}
+		outcomes = append(outcomes, Outcome{Unit: service, Action: action})
+	}
+	return outcomes, nil
+}
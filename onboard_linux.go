@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// interactive reports whether both standard input and output are
+// terminals.
+func interactive() bool {
+	for _, f := range [...]*os.File{os.Stdin, os.Stdout} {
+		if _, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// persisted reports whether any persistence units have been installed,
+// which is taken to mean that bat has been set up before.
+func persisted(units Units) bool {
+	if elogind() {
+		_, err := os.Stat(filepath.Join(hooks, "bat"))
+		return err == nil
+	}
+	matches, err := filepath.Glob(filepath.Join(units.Dir, units.name("*")))
+	return err == nil && len(matches) > 0
+}
+
+// onboard summarises the state of the battery and offers to set the
+// recommended threshold and persist it.
+func onboard(ctx context.Context, bat *Device, batteries []*Device) {
+	fmt.Printf("Found battery %s.\n", bat.Name)
+	if bat.Capabilities()&HasHealth != 0 {
+		health, err := bat.health()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Health: %d%%\n", health)
+	}
+	if bat.Capabilities()&HasThreshold == 0 {
+		fmt.Println(missing(bat))
+		fmt.Println("Run `bat --help` to see a list of available commands.")
+		return
+	}
+	v, err := bat.read(threshold)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Charging threshold: %s%%\n\n", v)
+
+	if v != strconv.Itoa(recommended) {
+		fmt.Printf("Limiting the charging threshold to %d%% prolongs the life-span of batteries\n"+
+			"that are mostly plugged in.\n", recommended)
+		if confirm(fmt.Sprintf("Set the charging threshold to %d%%?", recommended)) {
+			check(ctx, bat.set(threshold, recommended))
+			fmt.Println("Charging threshold set.")
+		}
+	}
+	if confirm("Persist the charging threshold between restarts?") {
+		outcomes, err := persist(ctx, defaultUnits, batteries)
+		report(outcomes)
+		check(ctx, err)
+		fmt.Println("Persistence of the current charging threshold enabled.")
+	}
+	fmt.Println("Run `bat --help` to see a list of available commands.")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// redirect replaces standard output with an anonymous temporary file in
+// the directory of path. Since the file has no name it disappears if the
+// program exits without calling commit, so a failed command never leaves
+// partial output behind.
+func redirect(path string) (*os.File, error) {
+	fd, err := unix.Open(filepath.Dir(path), unix.O_TMPFILE|unix.O_RDWR|unix.O_CLOEXEC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	tmp := os.NewFile(uintptr(fd), path)
+	os.Stdout = tmp
+	return tmp, nil
+}
+
+// commit writes the output collected in tmp to path, either atomically
+// replacing it or appending to it in a single write.
+func commit(tmp *os.File, path string, appending bool) error {
+	defer tmp.Close()
+	if appending {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		contents, err := io.ReadAll(tmp)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(contents); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	// An anonymous file can only be given a name through its descriptor
+	// in /proc, and linkat does not replace existing files, so link it
+	// under a temporary name and rename that over path.
+	name := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+strconv.Itoa(os.Getpid()))
+	source := filepath.Join("/", "proc", "self", "fd", strconv.Itoa(int(tmp.Fd())))
+	if err := unix.Linkat(unix.AT_FDCWD, source, unix.AT_FDCWD, name, unix.AT_SYMLINK_FOLLOW); err != nil {
+		return err
+	}
+	if err := os.Rename(name, path); err != nil {
+		return errors.Join(err, os.Remove(name))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Peripheral is the battery of a device other than the system, such as a
+// wireless mouse, keyboard, stylus or dock, which the kernel reports with
+// a device scope.
+type Peripheral struct {
+	// Name is that of the power supply, such as hidpp_battery_0, and Model
+	// the friendlier name given by the device, if any.
+	Name, Model string
+	// Capacity is the level in percent, or -1 for devices that only report
+	// a coarse Level such as Low.
+	Capacity int
+	Level    string
+	Status   string
+}
+
+// peripherals returns the batteries of the devices attached to the system.
+func peripherals() ([]Peripheral, error) {
+	roots, err := sysfs.Glob(filepath.Join(supplies, "*"))
+	if err != nil {
+		return nil, err
+	}
+	ps := make([]Peripheral, 0)
+	for _, root := range roots {
+		b := &battery{root: root}
+		if err := b.snapshot(); err != nil {
+			return nil, err
+		}
+		read := func(variable string) (string, error) {
+			v, err := b.read(variable)
+			if errors.Is(err, fs.ErrNotExist) {
+				return "", nil
+			}
+			return v, err
+		}
+		kind, err := read("type")
+		if err != nil {
+			return nil, err
+		}
+		scope, err := read("scope")
+		if err != nil {
+			return nil, err
+		}
+		if kind != "Battery" || scope != "Device" {
+			continue
+		}
+		p := Peripheral{Name: filepath.Base(root), Capacity: -1}
+		var model, manufacturer, capacity string
+		for _, v := range [...]struct {
+			variable string
+			value    *string
+		}{
+			{"model_name", &model},
+			{"manufacturer", &manufacturer},
+			{"capacity", &capacity},
+			{"capacity_level", &p.Level},
+			{"status", &p.Status},
+		} {
+			if *v.value, err = read(v.variable); err != nil {
+				return nil, err
+			}
+		}
+		// Some drivers already include the manufacturer in the model name.
+		p.Model = model
+		if manufacturer != "" && !strings.HasPrefix(model, manufacturer) {
+			p.Model = strings.TrimSpace(manufacturer + " " + model)
+		}
+		if capacity != "" {
+			if p.Capacity, err = strconv.Atoi(capacity); err != nil {
+				return nil, err
+			}
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// printPeripherals writes a table of the peripherals to w.
+func printPeripherals(w io.Writer, ps []Peripheral) error {
+	t := Table{Header: []string{"DEVICE", "MODEL", "LEVEL", "STATUS"}, Right: []int{2}}
+	for _, p := range ps {
+		model, level, status := p.Model, p.Level, p.Status
+		if model == "" {
+			model = "-"
+		}
+		if p.Capacity >= 0 {
+			level = numbers.Percent(float64(p.Capacity), 0)
+		}
+		if level == "" {
+			level = "-"
+		}
+		if status == "" {
+			status = "-"
+		}
+		t.Append(p.Name, model, level, status)
+	}
+	return t.Render(w)
+}
+
+// cmdPeripherals prints the batteries of wireless devices.
+func cmdPeripherals(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	ps, err := peripherals()
+	if err != nil {
+		panic(err)
+	}
+	if len(ps) == 0 {
+		return userError("No peripheral batteries found.")
+	}
+	if err := printPeripherals(os.Stdout, ps); err != nil {
+		panic(err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var stdin = bufio.NewScanner(os.Stdin)
+
+// prompt asks for input until valid accepts it. It exits if the input is
+// closed before a valid answer is given.
+func prompt(question string, valid func(answer string) bool) string {
+	for {
+		fmt.Print(question)
+		if !stdin.Scan() {
+			fmt.Println()
+			os.Exit(1)
+		}
+		if answer := strings.TrimSpace(stdin.Text()); valid(answer) {
+			return answer
+		}
+	}
+}
+
+// confirm asks a yes or no question, defaulting to no.
+func confirm(question string) bool {
+	answer := prompt(question+" [y/N] ", func(string) bool { return true })
+	return strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	dmi = filepath.Join("/", "sys", "class", "dmi", "id")
+	// probed caches the thresholds found to be accepted by probing the
+	// machine, which take precedence over the known quirks.
+	probed = filepath.Join("/", "var", "lib", "bat", "quirks")
+	// quirksDir holds the entries added by users and distributions, which
+	// take precedence over the embedded ones.
+	quirksDir = filepath.Join("/", "etc", "bat", "quirks.d")
+
+	//go:embed quirks.toml
+	embeddedQuirks string
+)
+
+// Quirk describes the oddities of a machine: a driver that only accepts
+// some threshold values and silently rejects or rounds the others, a
+// threshold found in an unusual place, or firmware that resets it after
+// resuming.
+type Quirk struct {
+	// Vendor and Product are matched against the prefix of the DMI system
+	// vendor and product name. An empty Product matches every model.
+	Vendor, Product string
+	// Values are the accepted thresholds in ascending order, or nil if
+	// every value is.
+	Values []int
+	// Control is the file holding the end threshold where the battery
+	// lacks one, relative to the battery directory unless absolute.
+	Control string
+	// ResumeDelay is how long to wait, in seconds, after resuming before
+	// restoring the threshold on machines whose embedded controller resets
+	// it some time after waking up.
+	ResumeDelay int
+}
+
+// parseQuirks reads entries in the flat subset of TOML accepted by
+// loadConfig, each starting with a [[quirk]] header.
+func parseQuirks(r io.Reader, name string) ([]Quirk, error) {
+	qs := make([]Quirk, 0)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(uncomment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if line == "[[quirk]]" {
+			qs = append(qs, Quirk{})
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value: %w", name, n, errConfig)
+		}
+		if len(qs) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected [[quirk]]: %w", name, n, errConfig)
+		}
+		q := &qs[len(qs)-1]
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "vendor":
+			q.Vendor, err = strconv.Unquote(value)
+		case "product":
+			q.Product, err = strconv.Unquote(value)
+		case "values":
+			q.Values, err = parseValues(value)
+		case "control":
+			q.Control, err = strconv.Unquote(value)
+		case "resume_delay":
+			q.ResumeDelay, err = strconv.Atoi(value)
+			if err == nil && (q.ResumeDelay < 0 || q.ResumeDelay > 60) {
+				err = errors.New("should be between 0 and 60")
+			}
+		default:
+			err = errors.New("unknown key")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v: %w", name, n, key, err, errConfig)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for _, q := range qs {
+		if q.Vendor == "" {
+			return nil, fmt.Errorf("%s: quirk without a vendor: %w", name, errConfig)
+		}
+	}
+	return qs, nil
+}
+
+// parseValues parses an array of thresholds such as [60, 80, 100].
+func parseValues(s string) ([]int, error) {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return nil, errors.New("should be an array")
+	}
+	values := make([]int, 0)
+	for _, field := range strings.Split(s[1:len(s)-1], ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		v, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		if v < 1 || v > 100 {
+			return nil, errors.New("should be between 1 and 100")
+		}
+		values = append(values, v)
+	}
+	slices.Sort(values)
+	return values, nil
+}
+
+// loadQuirks returns the entries in quirksDir, in the order of their file
+// names, followed by the embedded ones.
+func loadQuirks() ([]Quirk, error) {
+	names, err := filepath.Glob(filepath.Join(quirksDir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	qs := make([]Quirk, 0)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseQuirks(f, name)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, entries...)
+	}
+	entries, err := parseQuirks(strings.NewReader(embeddedQuirks), "quirks.toml")
+	if err != nil {
+		panic(err)
+	}
+	return append(qs, entries...), nil
+}
+
+// machine returns the first entry matching the machine, with Vendor and
+// Product set to those of the machine whether or not one matches.
+// Malformed entries in quirksDir are reported by doctor and ignored here in
+// favour of the embedded ones. It is only looked up once since the paths
+// of the controls depend on it.
+var machine = sync.OnceValue(func() Quirk {
+	vendor, err := readString(filepath.Join(dmi, "sys_vendor"))
+	if err != nil {
+		return Quirk{}
+	}
+	product, err := readString(filepath.Join(dmi, "product_name"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Quirk{}
+	}
+	qs, err := loadQuirks()
+	if err != nil {
+		qs, _ = parseQuirks(strings.NewReader(embeddedQuirks), "quirks.toml")
+	}
+	for _, q := range qs {
+		if strings.HasPrefix(vendor, q.Vendor) && strings.HasPrefix(product, q.Product) {
+			q.Vendor, q.Product = vendor, product
+			return q
+		}
+	}
+	return Quirk{Vendor: vendor, Product: product}
+})
+
+// machineQuirk returns the quirk matching the machine if it restricts the
+// threshold values, preferring those found by probing.
+func machineQuirk() (Quirk, bool) {
+	q := machine()
+	if p, err := loadProbed(); err == nil && p.Vendor == q.Vendor && p.Product == q.Product && q.Vendor != "" {
+		// Every value being accepted means there is no quirk.
+		return p, len(p.Values) < 100
+	}
+	return q, len(q.Values) > 0
+}
+
+// nearest returns the accepted value closest to v, preferring the lower
+// one on ties since a lower threshold is the safer choice.
+func (q Quirk) nearest(v int) int {
+	best := q.Values[0]
+	for _, value := range q.Values[1:] {
+		if abs(value-v) < abs(best-v) {
+			best = value
+		}
+	}
+	return best
+}
+
+// message returns the explanation shown when v is not accepted.
+func (q Quirk) message(v int) string {
+	values := make([]string, len(q.Values))
+	for i, value := range q.Values {
+		values[i] = strconv.Itoa(value)
+	}
+	return fmt.Sprintf("This device only accepts thresholds of %s%%. Try %d instead.",
+		strings.Join(values, ", "), q.nearest(v))
+}
+
+// loadProbed returns the quirk saved by saveProbed.
+func loadProbed() (Quirk, error) {
+	contents, err := os.ReadFile(probed)
+	if err != nil {
+		return Quirk{}, err
+	}
+	fields := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\t")
+	if len(fields) != 3 {
+		return Quirk{}, fmt.Errorf("%s: malformed cache", probed)
+	}
+	q := Quirk{Vendor: fields[0], Product: fields[1]}
+	for _, field := range strings.Fields(fields[2]) {
+		v, err := strconv.Atoi(field)
+		if err != nil {
+			return Quirk{}, fmt.Errorf("%s: malformed cache: %w", probed, err)
+		}
+		q.Values = append(q.Values, v)
+	}
+	if len(q.Values) == 0 {
+		return Quirk{}, fmt.Errorf("%s: malformed cache", probed)
+	}
+	return q, nil
+}
+
+// saveProbed caches the thresholds accepted by the machine as a single
+// line holding the DMI vendor, product and values separated by tabs.
+func saveProbed(values []int) error {
+	read := func(variable string) string {
+		v, _ := readString(filepath.Join(dmi, variable))
+		return v
+	}
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	if err := os.MkdirAll(filepath.Dir(probed), 0o755); err != nil {
+		return err
+	}
+	line := read("sys_vendor") + "\t" + read("product_name") + "\t" + strings.Join(s, " ") + "\n"
+	return os.WriteFile(probed, []byte(line), 0o644)
+}
+
+// probe tries every end threshold by writing it and reading it back, and
+// returns those the driver accepts in ascending order. The start threshold,
+// if any, is lowered for the duration so that it does not rule out low
+// values. The original thresholds are restored afterwards, even if probing
+// is interrupted.
+func (d *Device) probe(ctx context.Context) (values []int, err error) {
+	end, err := d.readInt(threshold)
+	if err != nil {
+		return nil, err
+	}
+	lowest := 1
+	if d.Capabilities()&HasStartThreshold != 0 {
+		start, err := d.readInt(startThreshold)
+		if err != nil {
+			return nil, err
+		}
+		switch err := d.set(startThreshold, 0); {
+		case err == nil:
+			// The end threshold goes back first so that the start one is
+			// below it again.
+			defer func() {
+				if rerr := d.set(startThreshold, start); err == nil {
+					err = rerr
+				}
+			}()
+		case errors.Is(err, errNotApplied), errors.Is(err, unix.EINVAL):
+			lowest = start + 1
+		default:
+			return nil, err
+		}
+	}
+	defer func() {
+		if rerr := d.set(threshold, end); err == nil {
+			err = rerr
+		}
+	}()
+	for v := 100; v >= lowest; v-- {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		switch err := d.set(threshold, v); {
+		case err == nil:
+			values = append(values, v)
+		case errors.Is(err, errNotApplied), errors.Is(err, unix.EINVAL):
+		default:
+			return nil, err
+		}
+	}
+	slices.Reverse(values)
+	return values, nil
+}
+
+// ranges summarises values in ascending order as ranges, such as
+// "40-60, 80, 100".
+func ranges(values []int) string {
+	parts := make([]string, 0)
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		part := strconv.Itoa(values[i])
+		if j > i {
+			part += "-" + strconv.Itoa(values[j])
+		}
+		parts = append(parts, part)
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
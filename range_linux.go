@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errSpan  = errors.New("invalid duration")
+	errRange = errors.New("range ends before it starts")
+)
+
+// Range is the span of time a history view is limited to. A zero bound
+// leaves the range open at that end.
+type Range struct {
+	Since, Until time.Time
+}
+
+// contains reports whether t falls within the range.
+func (r Range) contains(t time.Time) bool {
+	return (r.Since.IsZero() || !t.Before(r.Since)) && (r.Until.IsZero() || t.Before(r.Until))
+}
+
+// dates are the accepted formats of an absolute bound of a range. A date
+// alone refers to its start.
+var dates = [...]string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// parseSpan parses a duration as time.ParseDuration does, but also in
+// days or weeks, such as 7d or 2w, since history is kept for months.
+func parseSpan(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil || v <= 0 {
+				return 0, fmt.Errorf("%w %q", errSpan, s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%w %q", errSpan, s)
+	}
+	return d, nil
+}
+
+// parseBound parses a bound of a range relative to now: now, today,
+// yesterday, a date or time in one of the dates, taken in the location of
+// now, or a duration ago such as 48h or 7d.
+func parseBound(s string, now time.Time) (time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch s {
+	case "now":
+		return now, nil
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+	for _, layout := range dates {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if d, err := parseSpan(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", s)
+}
+
+// parseRange returns the range given by --since and --until, where --last
+// stands for a --since of that long ago. Empty flags leave the range open.
+func parseRange(since, until, last string, now time.Time) (Range, error) {
+	var (
+		r   Range
+		err error
+	)
+	switch {
+	case last != "":
+		d, err := parseSpan(last)
+		if err != nil {
+			return Range{}, err
+		}
+		r.Since = now.Add(-d)
+	case since != "":
+		if r.Since, err = parseBound(since, now); err != nil {
+			return Range{}, err
+		}
+	}
+	if until != "" {
+		if r.Until, err = parseBound(until, now); err != nil {
+			return Range{}, err
+		}
+	}
+	if !r.Since.IsZero() && !r.Until.IsZero() && !r.Since.Before(r.Until) {
+		return Range{}, errRange
+	}
+	return r, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseSpan(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{in: "48h", want: 48 * time.Hour},
+		{in: "90m", want: 90 * time.Minute},
+		{in: "7d", want: 7 * 24 * time.Hour},
+		{in: "1.5d", want: 36 * time.Hour},
+		{in: "2w", want: 14 * 24 * time.Hour},
+		{in: "", err: true},
+		{in: "d", err: true},
+		{in: "0d", err: true},
+		{in: "-1d", err: true},
+		{in: "-2h", err: true},
+		{in: "0s", err: true},
+		{in: "7 d", err: true},
+		{in: "week", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSpan(tt.in)
+		if tt.err {
+			if !errors.Is(err, errSpan) {
+				t.Errorf("parseSpan(%q) = %v, %v, want %v", tt.in, got, err, errSpan)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseSpan(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseBound(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, berlin)
+	}
+	// Clocks go forward an hour at 02:00 on 2024-03-31 and back at 03:00
+	// on 2024-10-27 in Berlin.
+	spring, autumn := at(2024, 3, 31, 12, 0), at(2024, 10, 27, 12, 0)
+	tests := []struct {
+		name string
+		in   string
+		now  time.Time
+		want time.Time
+		err  bool
+	}{
+		{name: "now", in: "now", now: spring, want: spring},
+		{name: "today", in: "today", now: spring, want: at(2024, 3, 31, 0, 0)},
+		{name: "today after the change", in: "today", now: at(2024, 4, 1, 0, 30), want: at(2024, 4, 1, 0, 0)},
+		{name: "yesterday across spring", in: "yesterday", now: at(2024, 4, 1, 9, 0), want: at(2024, 3, 31, 0, 0)},
+		{name: "yesterday across autumn", in: "yesterday", now: at(2024, 10, 28, 9, 0), want: at(2024, 10, 27, 0, 0)},
+		{name: "date", in: "2024-03-31", now: spring, want: at(2024, 3, 31, 0, 0)},
+		{name: "time", in: "2024-03-31T06:00", now: spring, want: at(2024, 3, 31, 6, 0)},
+		{name: "time with space", in: "2024-10-27 06:00", now: autumn, want: at(2024, 10, 27, 6, 0)},
+		{name: "offset", in: "2024-03-31T06:00:00Z", now: spring, want: time.Date(2024, 3, 31, 6, 0, 0, 0, time.UTC)},
+		// Durations are elapsed time, so a day ago across a change is 23
+		// or 25 hours by the clock.
+		{name: "day across spring", in: "1d", now: spring, want: at(2024, 3, 30, 11, 0)},
+		{name: "day across autumn", in: "1d", now: autumn, want: at(2024, 10, 26, 13, 0)},
+		{name: "hours", in: "48h", now: spring, want: spring.Add(-48 * time.Hour)},
+		{name: "tomorrow", in: "tomorrow", now: spring, err: true},
+		{name: "month out of range", in: "2024-13-01", now: spring, err: true},
+		{name: "day out of range", in: "2024-02-30", now: spring, err: true},
+		{name: "empty", in: "", now: spring, err: true},
+		{name: "negative", in: "-1d", now: spring, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBound(tt.in, tt.now)
+			if tt.err {
+				if err == nil {
+					t.Errorf("parseBound(%q) = %v, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || !got.Equal(tt.want) {
+				t.Errorf("parseBound(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name               string
+		since, until, last string
+		want               Range
+		err                error
+	}{
+		{name: "open"},
+		{name: "last", last: "48h", want: Range{Since: now.Add(-48 * time.Hour)}},
+		{name: "since", since: "today", want: Range{Since: time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)}},
+		{name: "until", until: "yesterday", want: Range{Until: time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC)}},
+		{name: "both", since: "7d", until: "now", want: Range{Since: now.Add(-7 * 24 * time.Hour), Until: now}},
+		{name: "reversed", since: "today", until: "yesterday", err: errRange},
+		{name: "empty range", since: "today", until: "today", err: errRange},
+		{name: "invalid last", last: "today", err: errSpan},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRange(tt.since, tt.until, tt.last, now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("parseRange = %v, want %v", err, tt.err)
+			}
+			if !got.Since.Equal(tt.want.Since) || !got.Until.Equal(tt.want.Until) {
+				t.Errorf("parseRange = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := parseRange("someday", "", "", now); err == nil {
+		t.Error("parseRange(someday) succeeded, want error")
+	}
+}
+
+func TestRangeContains(t *testing.T) {
+	since := time.Date(2024, 3, 30, 0, 0, 0, 0, time.UTC)
+	until := since.Add(24 * time.Hour)
+	r := Range{Since: since, Until: until}
+	for _, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{since.Add(-time.Second), false},
+		{since, true},
+		{until.Add(-time.Second), true},
+		{until, false},
+	} {
+		if got := r.contains(tt.t); got != tt.want {
+			t.Errorf("contains(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+	if !(Range{}).contains(since) {
+		t.Error("open range does not contain every time")
+	}
+}
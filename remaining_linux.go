@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Estimate is the time until the battery is empty when discharging, or
+// until it reaches its charging threshold when charging.
+type Estimate struct {
+	Duration time.Duration
+	// Available is false if the battery is neither charging nor
+	// discharging, or there is not enough data to estimate.
+	Available bool
+	// FromHistory is true if the estimate is based on recent samples of
+	// the level because the driver does not report the rate.
+	FromHistory bool
+}
+
+// estimate returns the time until the battery is empty or reaches its
+// threshold. Drivers that only report the level and status are handled
+// by extrapolating from the samples taken by previous invocations.
+func (d *Device) estimate() (Estimate, error) {
+	status, err := d.read("status")
+	if err != nil {
+		return Estimate{}, err
+	}
+	if status != "Charging" && status != "Discharging" {
+		return Estimate{}, nil
+	}
+	limit := 100
+	if d.Capabilities()&HasThreshold != 0 {
+		limit, err = d.readInt(threshold)
+		if err != nil {
+			return Estimate{}, err
+		}
+	}
+
+	// Some devices use charge_* (µAh) and current_now (µA) and others
+	// energy_* (µWh) and power_now (µW).
+	var now, full, rate int
+	found := false
+	for _, names := range [...][3]string{
+		{"energy_now", "energy_full", "power_now"},
+		{"charge_now", "charge_full", "current_now"},
+	} {
+		values := [3]int{}
+		found = true
+		for i, name := range names {
+			v, err := d.read(name)
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					found = false
+					break
+				}
+				return Estimate{}, err
+			}
+			values[i], err = strconv.Atoi(v)
+			if err != nil {
+				return Estimate{}, err
+			}
+		}
+		if found {
+			now, full, rate = values[0], values[1], values[2]
+			break
+		}
+	}
+	if !found {
+		return d.extrapolate(status, limit)
+	}
+	// Some drivers report a negative rate while discharging.
+	rate = max(rate, -rate)
+	if rate == 0 {
+		return Estimate{}, nil
+	}
+	if status == "Discharging" {
+		return Estimate{Duration: hours(float64(now) / float64(rate)), Available: true}, nil
+	}
+	full = full * limit / 100
+	return Estimate{Duration: hours(float64(max(full-now, 0)) / float64(rate)), Available: true}, nil
+}
+
+// extrapolate estimates from the slope of the level over recent samples.
+func (d *Device) extrapolate(status string, limit int) (Estimate, error) {
+	samples, err := d.record(time.Now())
+	if err != nil {
+		return Estimate{}, err
+	}
+	perHour, ok := slope(samples)
+	if !ok {
+		return Estimate{FromHistory: true}, nil
+	}
+	level := samples[len(samples)-1].Capacity
+	switch {
+	case status == "Discharging" && perHour < 0:
+		return Estimate{Duration: hours(float64(level) / -perHour), Available: true, FromHistory: true}, nil
+	case status == "Charging" && perHour > 0:
+		return Estimate{Duration: hours(float64(max(limit-level, 0)) / perHour), Available: true, FromHistory: true}, nil
+	}
+	return Estimate{FromHistory: true}, nil
+}
+
+// hours converts a fractional number of hours to a duration.
+func hours(h float64) time.Duration {
+	return time.Duration(h * float64(time.Hour))
+}
+
+// timeFormats lists the accepted values of the --time-format flag.
+var timeFormats = [...]string{"short", "iso", "clock"}
+
+// clocks lists the accepted values of the clock configuration key, which
+// sets whether the clock format is read in 12 or 24 hours.
+var clocks = [...]string{"12h", "24h"}
+
+// formatDuration renders d in the given format, which is one of
+// timeFormats. The clock format is the time of day at which d elapses
+// from now, in the layout given by clockLayout(clock).
+func formatDuration(d time.Duration, format, clock string, now time.Time) string {
+	d = d.Round(time.Minute)
+	h, m := int(d.Hours()), int(d.Minutes())%60
+	switch format {
+	case "iso":
+		return fmt.Sprintf("PT%dH%dM", h, m)
+	case "clock":
+		return now.Add(d).Format(clockLayout(clock))
+	default:
+		if h == 0 {
+			return fmt.Sprintf("%dm", m)
+		}
+		return fmt.Sprintf("%dh %02dm", h, m)
+	}
+}
+
+// cmdRemaining prints the time until the battery is empty or charged.
+func cmdRemaining(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("remaining", flag.ExitOnError)
+	c, _ := mustConfig(ctx)
+	fallback := c.TimeFormat
+	if v := os.Getenv("BAT_TIME_FORMAT"); v != "" {
+		fallback = v
+	}
+	format := flags.String("time-format", fallback, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if !slices.Contains(timeFormats[:], *format) {
+		return userError("Time format should be one of `short`, `iso` or `clock`.")
+	}
+	e, err := bat.estimate()
+	if err != nil {
+		panic(err)
+	}
+	if !e.Available {
+		if e.FromHistory {
+			fmt.Fprintln(os.Stderr, "This battery does not report its charge rate and not enough samples have\n"+
+				"been taken to estimate it yet. Rerun this command in a few minutes.")
+		} else {
+			fmt.Fprintln(os.Stderr, "The battery is neither charging nor discharging.")
+		}
+		return errFailed
+	}
+	if e.FromHistory {
+		degraded("estimate-from-history")
+	}
+	fmt.Println(formatDuration(e.Duration, *format, c.Clock, time.Now()))
+	return nil
+}
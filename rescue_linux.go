@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+
+	"golang.org/x/sys/unix"
+)
+
+// rescue undoes whatever may be holding the batteries in an odd state
+// after experimenting, for panic-restore: it stops the daemon serving the
+// socket at path, which would otherwise revert the thresholds set here,
+// removes the rule
+// applying thresholds only on external power, the timers scheduled by
+// full-charge-at and vacation mode, then restores the automatic charge
+// behaviour, sets the threshold of every battery to value and updates the
+// verification unit to expect it. Each step is attempted even if an
+// earlier one failed, and its outcome returned so that it can be reported,
+// along with the pid of the daemon that was stopped, if any, and the
+// systemd unit that ran it. The daemon is not started again since it would
+// apply the thresholds of its policies on its first reading.
+func rescue(ctx context.Context, path string, batteries []*Device, value int) ([]Outcome, int, string, error) {
+	outcomes := make([]Outcome, 0)
+	var errs []error
+	step := func(subject, action string, err error) {
+		if err != nil {
+			action = fmt.Sprintf("failed (%v)", err)
+			errs = append(errs, err)
+		}
+		outcomes = append(outcomes, Outcome{Unit: subject, Action: action})
+	}
+
+	pid, unit, err := stopDaemon(ctx, pidFile(path))
+	if pid != 0 || err != nil {
+		subject := fmt.Sprintf("daemon (pid %d)", pid)
+		if unit != "" {
+			subject = unit
+		}
+		step(subject, "stopped", err)
+	}
+	// A daemon that failed to stop is not reported as stopped.
+	if err != nil {
+		pid, unit = 0, ""
+	}
+	removed, err := removeACOnly(ctx)
+	if removed || err != nil {
+		step(acRules, "removed", err)
+	}
+	for _, b := range batteries {
+		removed, err := unschedule(ctx, b.Name)
+		for _, timer := range removed {
+			step(timer, "removed", nil)
+		}
+		if err != nil {
+			step(charges(b.Name)[0]+".timer", "", err)
+		}
+	}
+	if ctx.Err() != nil {
+		return outcomes, pid, unit, ctx.Err()
+	}
+	if err := os.Remove(vacation); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		step(vacation, "removed", err)
+	}
+
+	for _, b := range batteries {
+		if b.Capabilities()&HasChargeBehaviour != 0 {
+			action := "unchanged"
+			v, err := b.read("charge_behaviour")
+			if m := active.FindStringSubmatch(v); err == nil && m != nil && m[1] != "auto" {
+				action = "auto"
+				err = b.write("charge_behaviour", []byte("auto"))
+			}
+			step(b.Name+" charge_behaviour", action, err)
+		}
+		if b.Capabilities()&HasThreshold != 0 {
+			v := value
+			// The nearest accepted value is the safest on devices that
+			// reject others.
+			if q, ok := machineQuirk(); ok && !slices.Contains(q.Values, v) {
+				v = q.nearest(v)
+			}
+			err := b.set(threshold, v)
+			if err == nil {
+				audit(b.Name, v)
+			}
+			step(b.Name+" threshold", fmt.Sprintf("%d%%", v), err)
+		}
+	}
+	// The verification unit would otherwise warn about the thresholds
+	// just replaced.
+	verified, err := updateVerifier(ctx, defaultUnits, batteries)
+	for _, o := range verified {
+		step(o.Unit, o.Action, nil)
+	}
+	if err != nil {
+		step(defaultUnits.verifier(), "", err)
+	}
+	return outcomes, pid, unit, errors.Join(errs...)
+}
+
+// cmdPanicRestore undoes whatever experiments have left the batteries in.
+func cmdPanicRestore(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("panic-restore", flag.ExitOnError)
+	path := flags.String("socket", daemonSocket, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	// A malformed configuration is no reason not to recover, so the
+	// threshold falls back to 100.
+	value := 100
+	c, _, err := findConfig()
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		err = c.override(nil)
+	}
+	if err == nil && c.Threshold != 0 {
+		value = c.Threshold
+	}
+	outcomes, stopped, unit, err := rescue(ctx, *path, batteries, value)
+	report(outcomes)
+	if stopped != 0 {
+		start := "bat daemon"
+		if unit != "" {
+			start = "sudo systemctl start " + unit
+		}
+		fmt.Fprintf(os.Stderr, "The daemon (pid %d) is no longer running. Start it again with `%s`\n"+
+			"if it is still wanted.\n", stopped, start)
+	}
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, unix.EACCES) {
+			if err != nil {
+				return err
+			}
+		}
+		return userError("Some steps failed. The others have been applied.")
+	}
+	fmt.Println("Charging restored.")
+	updatePersisted(ctx, batteries)
+	return nil
+}
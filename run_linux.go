@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// errScript is returned for scripts naming commands that cannot be run
+// from one.
+var errScript = errors.New("invalid script")
+
+// unscripted are the commands that cannot be run from a script: run itself
+// and those that do not return on their own.
+var unscripted = [...]string{"daemon", "events", "run", "simulate-drain", "top"}
+
+// parseScript reads a script of bat commands, one per line with the
+// arguments separated by spaces. Blank lines and lines starting with # are
+// skipped. Every command is checked before any is run.
+func parseScript(r io.Reader) ([][]string, error) {
+	script := make([][]string, 0)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args := strings.Fields(line)
+		if args[0] == "bat" {
+			args = args[1:]
+		}
+		if len(args) == 0 || !slices.Contains(commands(), args[0]) || slices.Contains(unscripted[:], args[0]) || prompting(args) {
+			return nil, fmt.Errorf("line %d: %q: %w", n, line, errScript)
+		}
+		script = append(script, args)
+	}
+	return script, scanner.Err()
+}
+
+// prompting reports whether the command asks for input, which would be
+// taken from the script itself when it is read from stdin: threshold with
+// --ask or --slider, and doctor --fix without --yes.
+func prompting(args []string) bool {
+	has := func(name string) bool {
+		for _, arg := range args[1:] {
+			if arg == "--" {
+				break
+			}
+			// Flags are given with one dash or two.
+			flag := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+			if flag != arg && (flag == name || flag == name+"=true") {
+				return true
+			}
+		}
+		return false
+	}
+	switch args[0] {
+	case "threshold":
+		return has("ask") || has("slider")
+	case "doctor":
+		return has("fix") && !has("yes")
+	}
+	return false
+}
+
+// runScript runs each command of the script in turn, stopping at the first
+// that fails, which is returned. The thresholds and persistence are then
+// restored to what they were before the script started, so that it either
+// takes effect as a whole or not at all. Vacation mode, the timers of
+// full-charge-at and the rule of `threshold --on-ac-only` are left as the
+// commands left them.
+func runScript(ctx context.Context, script [][]string, batteries []*Device, units Units) ([]string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	before := make([]Setup, len(batteries))
+	for i, b := range batteries {
+		if before[i], err = observe(b, units); err != nil {
+			return nil, err
+		}
+	}
+	for _, args := range script {
+		cmd := exec.CommandContext(ctx, path, args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err = cmd.Run(); err == nil {
+			continue
+		}
+		// The interrupt has been delivered to the command too, and the
+		// rollback should still run.
+		ctx := context.WithoutCancel(ctx)
+		for i, b := range batteries {
+			_, outcomes, rerr := converge(ctx, b, batteries, units, before[i])
+			report(outcomes)
+			if rerr != nil {
+				return args, errors.Join(err, rerr)
+			}
+		}
+		return args, err
+	}
+	return nil, nil
+}
+
+// cmdRun runs the commands in a script.
+func cmdRun(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	r := os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return userError(fmt.Sprintf("%v.", err))
+		}
+		defer f.Close()
+		r = f
+	}
+	script, err := parseScript(r)
+	if err != nil {
+		if errors.Is(err, errScript) {
+			return userError(fmt.Sprintf("%s: %v. Only bat commands that return on their own\nwithout asking for input can be run.", args[0], err))
+		}
+		panic(err)
+	}
+	failed, err := runScript(ctx, script, batteries, defaultUnits)
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return userError(fmt.Sprintf("Stopped at `bat %s`. Earlier changes have been undone.", strings.Join(failed, " ")))
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+	}{
+		{line: "threshold 80", ok: true},
+		{line: "bat persist", ok: true},
+		{line: "doctor", ok: true},
+		{line: "doctor --fix --yes", ok: true},
+		{line: "threshold --ask=false 80", ok: true},
+		{line: "threshold --slider"},
+		{line: "threshold -ask"},
+		{line: "threshold --ask=true"},
+		{line: "doctor --fix"},
+		{line: "doctor -fix"},
+		{line: "daemon"},
+		{line: "run script"},
+		{line: "frobnicate"},
+	}
+	for _, tt := range tests {
+		_, err := parseScript(strings.NewReader("# comment\n\n" + tt.line + "\n"))
+		if tt.ok && err != nil {
+			t.Errorf("parseScript(%q) = %v, want nil", tt.line, err)
+		}
+		if !tt.ok && !errors.Is(err, errScript) {
+			t.Errorf("parseScript(%q) = %v, want %v", tt.line, err, errScript)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Sample is a reading of the battery level at a point in time.
+type Sample struct {
+	Time     time.Time
+	Capacity int
+	Status   string
+}
+
+// window is how far back samples are kept for extrapolation.
+const window = time.Hour
+
+// stateDir returns the directory in which per-user state is kept,
+// following the XDG Base Directory Specification.
+func stateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "bat"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "bat"), nil
+}
+
+// record appends the current reading to the samples of the device, drops
+// those older than the window or taken under a different status, and
+// returns the rest in chronological order.
+func (d *Device) record(now time.Time) ([]Sample, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "samples-"+d.Name)
+
+	capacity, err := d.readInt("capacity")
+	if err != nil {
+		return nil, err
+	}
+	status, err := d.read("status")
+	if err != nil {
+		return nil, err
+	}
+	current := Sample{Time: now, Capacity: capacity, Status: status}
+
+	samples, err := loadLines(path, func(line string) (Sample, bool) {
+		var (
+			seconds int64
+			s       Sample
+		)
+		if _, err := fmt.Sscanf(line, "%d %d %s", &seconds, &s.Capacity, &s.Status); err != nil {
+			return s, false
+		}
+		s.Time = time.Unix(seconds, 0)
+		return s, now.Sub(s.Time) <= window && s.Status == status
+	})
+	if err != nil {
+		return nil, err
+	}
+	samples = append(samples, current)
+	// Statuses are single words so a space-separated format suffices.
+	return samples, saveLines(path, samples, func(s Sample) string {
+		return fmt.Sprintf("%d %d %s", s.Time.Unix(), s.Capacity, s.Status)
+	})
+}
+
+// loadLines parses each line of the file at path with parse and returns
+// the values it accepts in order, or none if the file does not exist.
+// Corrupt lines are rejected by parse and skipped rather than failing the
+// command.
+func loadLines[T any](path string, parse func(line string) (T, bool)) ([]T, error) {
+	values := make([]T, 0)
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return values, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := parse(scanner.Text()); ok {
+			values = append(values, v)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// saveLines replaces the file at path with the values, one per line as
+// given by format.
+func saveLines[T any](path string, values []T, format func(T) string) error {
+	var buf bytes.Buffer
+	for _, v := range values {
+		buf.WriteString(format(v) + "\n")
+	}
+	return writeAtomic(path, buf.Bytes())
+}
+
+// writeAtomic writes to a temporary file and renames it over path so that
+// concurrent invocations never see a partial file, creating the parent
+// directory if needed.
+func writeAtomic(path string, contents []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// regress returns the slope of the least squares line through the points,
+// or false if it is undefined.
+func regress(xs, ys []float64) (float64, bool) {
+	if len(xs) < 2 {
+		return 0, false
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		x, y := xs[i], ys[i]
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	n := float64(len(xs))
+	denominator := n*sxx - sx*sx
+	if denominator == 0 {
+		return 0, false
+	}
+	return (n*sxy - sx*sy) / denominator, true
+}
+
+// slope returns the rate of change of the level in percent per hour by
+// least squares, or false if the samples do not span a change in level.
+func slope(samples []Sample) (float64, bool) {
+	if len(samples) < 2 || samples[0].Capacity == samples[len(samples)-1].Capacity {
+		return 0, false
+	}
+	xs, ys := make([]float64, len(samples)), make([]float64, len(samples))
+	for i, s := range samples {
+		xs[i], ys[i] = s.Time.Sub(samples[0].Time).Hours(), float64(s.Capacity)
+	}
+	return regress(xs, ys)
+}
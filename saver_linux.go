@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// Saver switches the system to a power saving profile when the battery
+// runs low and back when external power returns.
+type Saver struct {
+	// Level is the capacity at or below which the saver is enabled, or
+	// zero if it is disabled.
+	Level int
+	// On and Off are shell commands run instead of switching the
+	// power-profiles-daemon profile, such as `cpupower frequency-set -g
+	// powersave`.
+	On, Off string
+}
+
+// saverProfile is the power-profiles-daemon profile switched to.
+const saverProfile = "power-saver"
+
+// shell runs a command line with sh.
+func shell(ctx context.Context, line string) ([]byte, error) {
+	output, err := runner.Run(exec.CommandContext(ctx, "sh", "-c", line))
+	if err != nil {
+		return output, fmt.Errorf("%s: %s: %w", line, bytes.TrimSpace(output), err)
+	}
+	return output, nil
+}
+
+// save enables the saver when every battery is discharging and one is at
+// or below the level, and restores the previous profile once they are
+// not. Failures are logged rather than stopping the daemon since the
+// profile is a convenience.
+func (d *daemon) save(ctx context.Context, states []State) {
+	if d.saver.Level == 0 {
+		return
+	}
+	low := false
+	for _, s := range states {
+		if s.Status != removed && s.Capacity <= d.saver.Level {
+			low = true
+		}
+	}
+	switch {
+	case !d.saving && discharging(states) && low:
+		var err error
+		if d.saver.On != "" {
+			_, err = shell(ctx, d.saver.On)
+		} else {
+			var output []byte
+			if output, err = runner.Run(exec.CommandContext(ctx, "powerprofilesctl", "get")); err == nil {
+				d.prior = string(bytes.TrimSpace(output))
+				_, err = runner.Run(exec.CommandContext(ctx, "powerprofilesctl", "set", saverProfile))
+			}
+		}
+		if err != nil {
+			if errors.Is(err, exec.ErrNotFound) {
+				err = errors.New("powerprofilesctl not found, set saver_on and saver_off instead")
+			}
+			d.log.Error("enabling power saver failed", "operation", "saver", "error", err)
+			return
+		}
+		d.saving = true
+		d.log.Info("enabled power saver", "operation", "saver", "value", d.saver.Level)
+	case d.saving && !discharging(states):
+		var err error
+		switch {
+		case d.saver.Off != "":
+			_, err = shell(ctx, d.saver.Off)
+		case d.prior != "":
+			_, err = runner.Run(exec.CommandContext(ctx, "powerprofilesctl", "set", d.prior))
+		}
+		if err != nil {
+			d.log.Error("restoring power profile failed", "operation", "saver", "error", err)
+			return
+		}
+		d.saving = false
+		d.log.Info("restored power profile", "operation", "saver", "value", d.prior)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+	"time"
+)
+
+// layouts are the accepted formats of the time given to full-charge-at.
+// A time of day alone refers to its next occurrence.
+var layouts = [...]string{"2006-01-02T15:04", "2006-01-02 15:04", "15:04"}
+
+// parseTime parses s in one of the layouts in the local time zone.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	for _, layout := range layouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err != nil {
+			continue
+		}
+		if layout == "15:04" {
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+			if !t.After(now) {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", s)
+}
+
+// Charge is the configuration of one of the pairs of timer and service
+// installed by full-charge-at, which write Value to the threshold at Path
+// at the time given by OnCalendar and then run Mark.
+type Charge struct {
+	Description, OnCalendar string
+	Shell, Path, Value      string
+	// Mark creates or removes the file in scheduled for the battery, so
+	// that the persistence units leave the full charge alone.
+	Mark string
+	// After is the unit to run first when both timers elapsed while the
+	// machine was off.
+	After string
+}
+
+// scheduled holds a file for each battery that full-charge-at is charging
+// fully.
+var scheduled = filepath.Join("/", "var", "lib", "bat", "full-charge")
+
+var (
+	//go:embed charge.service
+	chargeService string
+
+	//go:embed charge.timer
+	chargeTimer string
+)
+
+// charges returns the names of the units installed by schedule for the
+// battery, the full charge first.
+func charges(name string) [2]string {
+	return [...]string{"bat-full-charge-" + name, "bat-restore-charge-" + name}
+}
+
+// schedule installs timers that raise the threshold of the battery to 100
+// at the given time and restore the current one after the duration, so
+// that it is topped up before a known trip without being left full for
+// days. Earlier schedules for the battery are replaced. The timers are
+// persistent, so they survive a restart and one that elapsed while the
+// machine was off fires once it is back on.
+func schedule(ctx context.Context, d *Device, at time.Time, restore time.Duration) error {
+	v, err := d.read(threshold)
+	if err != nil {
+		return err
+	}
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return errNoShell
+		}
+		return err
+	}
+	touch, err := exec.LookPath("touch")
+	if err != nil {
+		return err
+	}
+	rm, err := exec.LookPath("rm")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(scheduled, 0o755); err != nil {
+		return err
+	}
+	mark := filepath.Join(scheduled, d.Name)
+	units := charges(d.Name)
+	cs := [...]Charge{
+		{
+			Description: "Charge " + d.Name + " fully",
+			OnCalendar:  at.Format("2006-01-02 15:04:05"),
+			Shell:       shell,
+			Path:        d.path(threshold),
+			Value:       "100",
+			Mark:        touch + " " + mark,
+		},
+		{
+			Description: "Restore the charging threshold of " + d.Name,
+			OnCalendar:  at.Add(restore).Format("2006-01-02 15:04:05"),
+			Shell:       shell,
+			Path:        d.path(threshold),
+			Value:       v,
+			Mark:        rm + " -f " + mark,
+			After:       units[0] + ".service",
+		},
+	}
+	for i, c := range cs {
+		// Stopping a timer that does not exist fails harmlessly. This also
+		// stops the transient timers of earlier versions.
+		systemctl(ctx, "stop", units[i]+".timer")
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		for _, u := range [...]struct{ name, text string }{
+			{units[i] + ".service", chargeService},
+			{units[i] + ".timer", chargeTimer},
+		} {
+			var buf bytes.Buffer
+			if err := template.Must(template.New(u.name).Parse(u.text)).Execute(&buf, c); err != nil {
+				return err
+			}
+			if err := checkUnit("/", u.name, buf.Bytes()); err != nil {
+				return err
+			}
+			name := filepath.Join(services, u.name)
+			if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+				return err
+			}
+			if err := relabel(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := systemctl(ctx, "daemon-reload"); err != nil {
+		return err
+	}
+	for _, unit := range units {
+		if _, err := systemctl(ctx, "enable", "--now", unit+".timer"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unschedule disables and removes the timers installed by schedule for the
+// battery, returning those that were installed, along with the file
+// marking a full charge in progress.
+func unschedule(ctx context.Context, name string) ([]string, error) {
+	removed := make([]string, 0)
+	for _, unit := range charges(name) {
+		timer := filepath.Join(services, unit+".timer")
+		if _, err := os.Stat(timer); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Transient timers of earlier versions are only stopped.
+				if _, err := systemctl(ctx, "stop", unit+".timer"); err == nil {
+					removed = append(removed, unit+".timer")
+				}
+				continue
+			}
+			return removed, err
+		}
+		if _, err := systemctl(ctx, "disable", "--now", unit+".timer"); err != nil {
+			return removed, err
+		}
+		if err := errors.Join(os.Remove(timer), os.Remove(filepath.Join(services, unit+".service"))); err != nil {
+			return removed, err
+		}
+		removed = append(removed, unit+".timer")
+	}
+	if err := os.Remove(filepath.Join(scheduled, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// cmdFullChargeAt schedules a full charge at the given time.
+func cmdFullChargeAt(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("full-charge-at", flag.ExitOnError)
+	restore := flags.Duration("for", 12*time.Hour, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	if bat.Capabilities()&HasThreshold == 0 {
+		return userError(missing(bat))
+	}
+	now := time.Now()
+	at, err := parseTime(flags.Arg(0), now)
+	if err != nil {
+		return userError("The time should be of the form 2024-07-01T06:00 or 06:00.")
+	}
+	if !at.After(now) {
+		return userError("The time should be in the future.")
+	}
+	if *restore <= 0 {
+		return userError("The duration should be positive.")
+	}
+	if err := schedule(ctx, bat, at, *restore); err != nil {
+		return err
+	}
+	fmt.Printf("The battery will charge fully from %s and the current threshold will\n"+
+		"be restored at %s. Run `systemctl list-timers 'bat-*'` to review.\n",
+		at.Format("Mon 2 Jan 15:04"), at.Add(*restore).Format("Mon 2 Jan 15:04"))
+	return nil
+}
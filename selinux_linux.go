@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// unitLabel is the SELinux type systemd expects unit files to have.
+const unitLabel = "systemd_unit_file_t"
+
+var selinuxfs = filepath.Join("/", "sys", "fs", "selinux")
+
+// relabel restores the default SELinux context of a newly written unit
+// file so that systemd is allowed to load it. Files created by a process
+// running in an unconfined context otherwise inherit the wrong type on
+// some systems. If restorecon is unavailable a warning is printed when
+// the label looks wrong instead.
+func relabel(ctx context.Context, path string) error {
+	if _, err := os.Stat(filepath.Join(selinuxfs, "enforce")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	restorecon, err := exec.LookPath("restorecon")
+	if err == nil {
+		output, err := runner.Run(exec.CommandContext(ctx, restorecon, path))
+		if err != nil {
+			return fmt.Errorf("restorecon: %s: %w", bytes.TrimSpace(output), err)
+		}
+		return nil
+	}
+	if !errors.Is(err, exec.ErrNotFound) {
+		return err
+	}
+	label := make([]byte, 256)
+	n, err := unix.Lgetxattr(path, "security.selinux", label)
+	if err != nil {
+		if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return err
+	}
+	if !bytes.Contains(label[:n], []byte(unitLabel)) {
+		fmt.Fprintf(
+			os.Stderr,
+			"%s has the SELinux context %s instead of %s and may fail\n"+
+				"to start. Run `restorecon %[1]s` to correct it.\n",
+			path, bytes.TrimRight(label[:n], "\x00"), unitLabel,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Simulation is a synthetic timeline played back against a fake
+// power_supply tree.
+type Simulation struct {
+	Root     string
+	From, To int
+	Limit    int
+	Interval time.Duration
+	// Charge makes the battery charge back up to the limit after reaching
+	// the lower level.
+	Charge bool
+}
+
+// simulated capacity and draw of the fake battery, in µWh and µW.
+const (
+	simulatedFull  = 50_000_000
+	simulatedPower = 10_000_000
+)
+
+// run creates a fake battery under the root and steps its level by one
+// percent every interval until the timeline ends or ctx is cancelled.
+func (s Simulation) run(ctx context.Context, progress func(level int, status string)) error {
+	bat := &battery{root: filepath.Join(s.Root, "BAT0")}
+	if err := os.MkdirAll(bat.root, 0o755); err != nil {
+		return err
+	}
+	fixed := map[string]string{
+		"type":               "Battery",
+		"present":            "1",
+		"energy_full":        strconv.Itoa(simulatedFull),
+		"energy_full_design": strconv.Itoa(simulatedFull),
+		"power_now":          strconv.Itoa(simulatedPower),
+		threshold:            strconv.Itoa(s.Limit),
+	}
+	for variable, value := range fixed {
+		// The fake tree is created here so the files are written directly
+		// rather than through sysfs, which only writes existing files.
+		if err := os.WriteFile(bat.path(variable), []byte(value+"\n"), 0o644); err != nil {
+			return err
+		}
+	}
+	set := func(level int, status string) error {
+		for variable, value := range map[string]string{
+			"capacity":   strconv.Itoa(level),
+			"energy_now": strconv.Itoa(simulatedFull / 100 * level),
+			"status":     status,
+		} {
+			if err := os.WriteFile(bat.path(variable), []byte(value+"\n"), 0o644); err != nil {
+				return err
+			}
+		}
+		progress(level, status)
+		return nil
+	}
+	step := func(level int, status string) error {
+		if err := set(level, status); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.Interval):
+			return nil
+		}
+	}
+
+	for level := s.From; level > s.To; level-- {
+		if err := step(level, "Discharging"); err != nil {
+			return err
+		}
+	}
+	if !s.Charge {
+		return set(s.To, "Discharging")
+	}
+	for level := s.To; level < s.Limit; level++ {
+		if err := step(level, "Charging"); err != nil {
+			return err
+		}
+	}
+	return set(s.Limit, "Not charging")
+}
+
+// simulationHint tells users how to point bat at the simulated tree.
+func simulationHint(root string) string {
+	return fmt.Sprintf("Simulating a battery under %s. Run other commands with\n"+
+		"BAT_SUPPLIES=%[1]s set to use it.", root)
+}
+
+// cmdSimulateDrain plays back a synthetic discharge against a fake battery.
+func cmdSimulateDrain(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("simulate-drain", flag.ExitOnError)
+	sim := Simulation{}
+	flags.StringVar(&sim.Root, "root", "", ignore)
+	flags.IntVar(&sim.From, "from", 100, ignore)
+	flags.IntVar(&sim.To, "to", 5, ignore)
+	flags.IntVar(&sim.Limit, "limit", recommended, ignore)
+	flags.DurationVar(&sim.Interval, "interval", time.Second, ignore)
+	flags.BoolVar(&sim.Charge, "charge", false, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if sim.To < 0 || sim.From > 100 || sim.To >= sim.From || sim.Limit < 1 || sim.Limit > 100 || sim.Interval <= 0 {
+		return userError("Invalid simulation parameters.")
+	}
+	if sim.Root == "" {
+		root, err := os.MkdirTemp("", "bat-simulation-")
+		if err != nil {
+			panic(err)
+		}
+		sim.Root = root
+	}
+	fmt.Fprintln(os.Stderr, simulationHint(sim.Root))
+	err := sim.run(ctx, func(level int, status string) {
+		fmt.Printf("%d %s\n", level, status)
+	})
+	if err != nil && ctx.Err() == nil {
+		panic(err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// width is the number of cells of the slider bar.
+const width = 50
+
+// slide lets the threshold be adjusted on the terminal f with the arrow
+// keys, in steps of the accepted values with left and right and of ten
+// with up and down, until q or Escape is pressed. Each press of Enter
+// passes the value to set, which applies it and returns the threshold read
+// back, shown beside the slider, or a message explaining why it could not.
+// It reports whether any threshold was set.
+func slide(ctx context.Context, f *os.File, current int, values []int, set func(int) (int, string)) (bool, error) {
+	fd := int(f.Fd())
+	saved, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return false, err
+	}
+	raw := *saved
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	// Reads time out every tenth of a second so that an interrupt is
+	// noticed without a key being pressed.
+	raw.Cc[unix.VMIN], raw.Cc[unix.VTIME] = 0, 1
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return false, err
+	}
+	defer unix.IoctlSetTermios(fd, unix.TCSETS, saved)
+
+	i, _ := slices.BinarySearch(values, current)
+	i = min(i, len(values)-1)
+	applied, message, changed := current, "", false
+	draw := func() {
+		filled := values[i] * width / 100
+		bar := strings.Repeat("=", filled) + strings.Repeat("-", width-filled)
+		fmt.Fprintf(f, "\r\x1b[K[%s] %3d%%  (set: %d%%)\n\x1b[K%s\x1b[A\r", bar, values[i], applied, message)
+	}
+	fmt.Fprintln(f, "Use the arrow keys to choose a threshold, Enter to set it and q to quit.")
+	draw()
+	defer fmt.Fprint(f, "\n\x1b[K")
+
+	buf := make([]byte, 8)
+	for {
+		n, err := f.Read(buf)
+		if ctx.Err() != nil {
+			return changed, ctx.Err()
+		}
+		// Reads that time out return io.EOF.
+		if err != nil && !errors.Is(err, io.EOF) {
+			return changed, err
+		}
+		if n == 0 {
+			continue
+		}
+		switch key := string(buf[:n]); key {
+		case "q", "\x1b":
+			return changed, nil
+		case "\x1b[D", "h":
+			i = max(i-1, 0)
+		case "\x1b[C", "l":
+			i = min(i+1, len(values)-1)
+		case "\x1b[B":
+			i = tenBelow(values, i)
+		case "\x1b[A":
+			i, _ = slices.BinarySearch(values, values[i]+10)
+			i = min(i, len(values)-1)
+		case "\r", "\n":
+			v, problem := set(values[i])
+			if message = problem; problem != "" {
+				break
+			}
+			applied, changed = v, true
+			if v != values[i] {
+				message = fmt.Sprintf("The device holds the threshold at %d%%.", v)
+			}
+		default:
+			continue
+		}
+		draw()
+	}
+}
+
+// tenBelow returns the index of the highest value at least ten below the
+// one at i, or of the previous one where the values are further apart,
+// which searching for the value ten below alone would not reach.
+func tenBelow(values []int, i int) int {
+	j, found := slices.BinarySearch(values, values[i]-10)
+	if !found {
+		j--
+	}
+	return max(min(j, i-1), 0)
+}
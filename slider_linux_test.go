@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestTenBelow(t *testing.T) {
+	every := make([]int, 0, 100)
+	for v := 1; v <= 100; v++ {
+		every = append(every, v)
+	}
+	tests := []struct {
+		name   string
+		values []int
+		from   int
+		want   int
+	}{
+		{name: "every value", values: every, from: 80, want: 70},
+		{name: "near the bottom", values: every, from: 5, want: 1},
+		{name: "bottom", values: every, from: 1, want: 1},
+		// Quirky devices accept values further apart than a step.
+		{name: "sparse", values: []int{40, 60, 80, 100}, from: 60, want: 40},
+		{name: "dense", values: []int{50, 55, 60, 65, 70}, from: 70, want: 60},
+		{name: "uneven", values: []int{40, 52, 60}, from: 60, want: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := 0
+			for tt.values[i] != tt.from {
+				i++
+			}
+			if got := tt.values[tenBelow(tt.values, i)]; got != tt.want {
+				t.Errorf("tenBelow from %d = %d, want %d", tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Source is an external power supply such as an AC adapter or a USB port.
+type Source struct {
+	Name, Type, USBType string
+	Online              bool
+}
+
+// Class returns the policy class of the source: ac for mains adapters,
+// usb-pd for USB Power Delivery sources such as power banks and USB-C
+// chargers, and usb for other USB sources.
+func (s Source) Class() string {
+	switch {
+	case s.Type == "Mains":
+		return "ac"
+	case strings.HasPrefix(s.USBType, "PD"):
+		return "usb-pd"
+	default:
+		return "usb"
+	}
+}
+
+// sourceClasses lists the values returned by Source.Class.
+var sourceClasses = [...]string{"ac", "usb-pd", "usb"}
+
+// active matches the bracketed, active entry in usb_type.
+var active = regexp.MustCompile(`\[([^\]]+)\]`)
+
+// sources returns the external power supplies on the system.
+func sources() ([]Source, error) {
+	entries, err := os.ReadDir(supplies)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]Source, 0)
+	for _, entry := range entries {
+		b := &battery{root: filepath.Join(supplies, entry.Name())}
+		kind, err := b.read("type")
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		if kind != "Mains" && kind != "USB" {
+			continue
+		}
+		s := Source{Name: entry.Name(), Type: kind}
+		v, err := b.read("online")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		s.Online = v == "1"
+		// The types a port supports are listed with the active one in
+		// brackets, e.g. "C [PD] PD_PPS".
+		if v, err := b.read("usb_type"); err == nil {
+			if m := active.FindStringSubmatch(v); m != nil {
+				s.USBType = m[1]
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// online returns the class of the source currently supplying power, or
+// the empty string when running on battery. Mains adapters take
+// precedence if several are online.
+func online(sources []Source) string {
+	class := ""
+	for _, s := range sources {
+		if !s.Online {
+			continue
+		}
+		if s.Class() == "ac" {
+			return "ac"
+		}
+		class = s.Class()
+	}
+	return class
+}
+
+// Policy is what to do while charging from a class of source: either hold
+// the battery at a threshold or inhibit charging altogether.
+type Policy struct {
+	Threshold int
+	Inhibit   bool
+}
+
+// parsePolicies parses policies of the form ac=80,usb-pd=60,usb=inhibit.
+// A policy for battery applies while no source is online, such as
+// battery=100 to only hold the threshold on external power.
+func parsePolicies(s string) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+	if s == "" {
+		return policies, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		class, value, ok := strings.Cut(pair, "=")
+		if !ok || (class != "battery" && !slices.Contains(sourceClasses[:], class)) {
+			return nil, fmt.Errorf("invalid policy %q", pair)
+		}
+		if value == "inhibit" {
+			policies[class] = Policy{Inhibit: true}
+			continue
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 1 || i > 100 {
+			return nil, fmt.Errorf("invalid policy %q", pair)
+		}
+		policies[class] = Policy{Threshold: i}
+	}
+	return policies, nil
+}
+
+// apply enforces the policy on the batteries that support it.
+func (p Policy) apply(batteries []*Device) error {
+	for _, b := range batteries {
+		if !b.present() {
+			continue
+		}
+		if b.Capabilities()&HasChargeBehaviour != 0 {
+			behaviour := "auto"
+			if p.Inhibit {
+				behaviour = "inhibit-charge"
+			}
+			if err := b.write("charge_behaviour", []byte(behaviour)); err != nil {
+				return err
+			}
+		}
+		if p.Threshold != 0 && b.Capabilities()&HasThreshold != 0 {
+			if err := b.set(threshold, p.Threshold); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cmdSource prints the class of the power source online.
+func cmdSource(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	srcs, err := sources()
+	if err != nil {
+		panic(err)
+	}
+	class := online(srcs)
+	if class == "" {
+		class = "battery"
+	}
+	fmt.Println(class)
+	return nil
+}
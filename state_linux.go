@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Setup describes the charging threshold of a battery and how it is
+// persisted, for configuration management tools that converge a system
+// to a desired state. Zero values are left as they are.
+type Setup struct {
+	Device    string `json:"device,omitempty"`
+	Threshold int    `json:"threshold,omitempty"`
+	Start     int    `json:"start,omitempty"`
+	// Persistence is one of none, systemd, elogind or sysext. The desired
+	// state may also give auto for whichever of systemd or elogind the
+	// system uses, as persist does.
+	Persistence string `json:"persistence,omitempty"`
+}
+
+// loadSetup reads a desired state from r.
+func loadSetup(r io.Reader) (Setup, error) {
+	var s Setup
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return Setup{}, fmt.Errorf("invalid state: %w", err)
+	}
+	return s, nil
+}
+
+// method returns the persistence method in use.
+func method(units Units) string {
+	if _, err := os.Stat(sysext); err == nil {
+		return "sysext"
+	}
+	if elogind() {
+		if _, err := os.Stat(filepath.Join(hooks, "bat")); err == nil {
+			return "elogind"
+		}
+		return "none"
+	}
+	if persisted(units) {
+		return "systemd"
+	}
+	return "none"
+}
+
+// observe returns the current state of d.
+func observe(d *Device, units Units) (Setup, error) {
+	s := Setup{Device: d.Name, Persistence: method(units)}
+	if d.Capabilities()&HasThreshold == 0 {
+		return s, nil
+	}
+	var err error
+	if s.Threshold, err = d.readInt(threshold); err != nil {
+		return Setup{}, err
+	}
+	if d.Capabilities()&HasStartThreshold != 0 {
+		if s.Start, err = d.readInt(startThreshold); err != nil {
+			return Setup{}, err
+		}
+	}
+	return s, nil
+}
+
+// differences describes how the current state departs from the desired
+// one, with one line per setting.
+func (s Setup) differences(want Setup) []string {
+	diffs := make([]string, 0)
+	if want.Threshold != 0 && s.Threshold != want.Threshold {
+		diffs = append(diffs, fmt.Sprintf("threshold: %d, want %d", s.Threshold, want.Threshold))
+	}
+	if want.Start != 0 && s.Start != want.Start {
+		diffs = append(diffs, fmt.Sprintf("start: %d, want %d", s.Start, want.Start))
+	}
+	if !s.persistedAs(want.Persistence) {
+		diffs = append(diffs, fmt.Sprintf("persistence: %s, want %s", s.Persistence, want.Persistence))
+	}
+	return diffs
+}
+
+// persistedAs reports whether the state is persisted by method, which
+// matches anything if empty.
+func (s Setup) persistedAs(method string) bool {
+	switch method {
+	case "", s.Persistence:
+		return true
+	case "auto":
+		return s.Persistence == "systemd" || s.Persistence == "elogind"
+	}
+	return false
+}
+
+// converge sets the thresholds of d and persists them as in want, leaving
+// settings that already match untouched. The outcomes of changes to the
+// persistence are returned along with whether anything changed.
+func converge(ctx context.Context, d *Device, batteries []*Device, units Units, want Setup) (bool, []Outcome, error) {
+	s, err := observe(d, units)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(s.differences(want)) == 0 {
+		return false, nil, nil
+	}
+
+	set := (want.Threshold != 0 && s.Threshold != want.Threshold) || (want.Start != 0 && s.Start != want.Start)
+	if set {
+		end := want.Threshold
+		if end == 0 {
+			end = s.Threshold
+		}
+		if want.Start != 0 {
+			err = d.setThresholds(want.Start, end)
+		} else {
+			err = d.set(threshold, end)
+		}
+		if err != nil {
+			return true, nil, err
+		}
+	}
+
+	if s.persistedAs(want.Persistence) {
+		// Installed units would otherwise restore the old thresholds.
+		_, outcomes, err := repersist(ctx, units, batteries)
+		return true, outcomes, err
+	}
+	var outcomes []Outcome
+	switch s.Persistence {
+	case "sysext":
+		outcomes, err = resetSysext(ctx)
+	case "elogind":
+		outcomes, err = resetElogind()
+	case "systemd":
+		outcomes, err = reconcile(ctx, units, nil)
+	}
+	if err != nil {
+		return true, outcomes, err
+	}
+	var installed []Outcome
+	switch want.Persistence {
+	case "sysext":
+		installed, err = persistSysext(ctx, units, batteries)
+	case "auto", "systemd", "elogind":
+		installed, err = persist(ctx, units, batteries)
+	}
+	return true, append(outcomes, installed...), err
+}
+
+// cmdState prints the charging state of the batteries, or checks or
+// converges it against the configuration.
+func cmdState(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	converging := len(args) > 0 && args[0] == "apply"
+	if converging {
+		args = args[1:]
+	}
+	flags := flag.NewFlagSet("state", flag.ExitOnError)
+	checking := flags.Bool("check", false, ignore)
+	name := flags.String("device", "", ignore)
+	end := flags.Int("threshold", 0, ignore)
+	start := flags.Int("start", 0, ignore)
+	persistence := flags.String("persistence", "", ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() > 1 || (converging && *checking) {
+		return errUsage
+	}
+	var (
+		want Setup
+		err  error
+	)
+	if flags.NArg() == 1 {
+		r := os.Stdin
+		if flags.Arg(0) != "-" {
+			f, err := os.Open(flags.Arg(0))
+			if err != nil {
+				return userError(fmt.Sprintf("%v.", err))
+			}
+			defer f.Close()
+			r = f
+		}
+		if want, err = loadSetup(r); err != nil {
+			return userError(fmt.Sprintf("%s: %v.", flags.Arg(0), err))
+		}
+	}
+	// Flags take precedence over the file.
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "device":
+			want.Device = *name
+		case "threshold":
+			want.Threshold = *end
+		case "start":
+			want.Start = *start
+		case "persistence":
+			want.Persistence = *persistence
+		}
+	})
+	if want.Threshold < 0 || want.Threshold > 100 || want.Start < 0 || want.Start > 99 || (want.Threshold != 0 && want.Start >= want.Threshold) {
+		return userError("The threshold should be between 1 and 100, and the start threshold below it.")
+	}
+	switch want.Persistence {
+	case "", "none", "auto", "sysext":
+	case "systemd", "elogind":
+		if elogind() != (want.Persistence == "elogind") {
+			return userError(fmt.Sprintf("Persistence through %s is not available on this system.", want.Persistence))
+		}
+	default:
+		return userError("Persistence should be one of `none`, `auto`, `systemd`, `elogind` or `sysext`.")
+	}
+	d := bat
+	if want.Device != "" {
+		var ok bool
+		if d, ok = device(batteries, want.Device); !ok {
+			return userError(fmt.Sprintf("There is no `%s` battery.", want.Device))
+		}
+	}
+
+	switch {
+	case *checking:
+		// Only the exit status matters to most callers, but the
+		// differences help when it is unexpected.
+		s, err := observe(d, defaultUnits)
+		if err != nil {
+			panic(err)
+		}
+		diffs := s.differences(want)
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+		if len(diffs) > 0 {
+			return errFailed
+		}
+	case converging:
+		if want.Threshold != 0 || want.Start != 0 {
+			if d.Capabilities()&HasThreshold == 0 {
+				return userError(missing(d))
+			}
+			if want.Start != 0 && d.Capabilities()&HasStartThreshold == 0 {
+				return userError(unsupported(d, HasStartThreshold))
+			}
+		}
+		if q, ok := machineQuirk(); ok && want.Threshold != 0 && !slices.Contains(q.Values, want.Threshold) {
+			return userError(q.message(want.Threshold))
+		}
+		changed, outcomes, err := converge(ctx, d, batteries, defaultUnits, want)
+		report(outcomes)
+		if errors.Is(err, errThresholdOrder) {
+			return userError("The start threshold should be below the end threshold.")
+		}
+		if err != nil {
+			return err
+		}
+		if !changed {
+			fmt.Println("Already in the desired state.")
+			return nil
+		}
+		fmt.Println("Desired state applied.")
+	default:
+		s, err := observe(d, defaultUnits)
+		if err != nil {
+			panic(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(s); err != nil {
+			panic(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+)
+
+// explain combines the raw status with the level and charging threshold
+// into a status that does not look like a fault when the battery is
+// deliberately held at its threshold. Drivers report this as "Not
+// charging" or, on some models, "Unknown".
+func (d *Device) explain() (string, error) {
+	status, err := d.read("status")
+	if err != nil {
+		return "", err
+	}
+	if d.Capabilities()&HasThreshold == 0 {
+		return status, nil
+	}
+	limit, err := d.readInt(threshold)
+	if err != nil {
+		return "", err
+	}
+	level, err := d.readInt("capacity")
+	if err != nil {
+		return "", err
+	}
+	switch status {
+	case "Charging":
+		if limit < 100 {
+			return fmt.Sprintf("Charging to %d%% limit", limit), nil
+		}
+	case "Not charging", "Unknown":
+		// Firmware stops a few percent short of the limit on some models.
+		if limit < 100 && level >= limit-tolerance {
+			return fmt.Sprintf("Held at %d%% limit", limit), nil
+		}
+	}
+	return status, nil
+}
+
+// tolerance is how far below the threshold the level may be for the
+// battery to be considered held at it.
+const tolerance = 3
+
+// cmdCapacity prints the charge level, or compares it with --below and
+// --above through the exit status.
+func cmdCapacity(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("capacity", flag.ExitOnError)
+	relative := flags.Bool("threshold-relative", false, ignore)
+	absolute := flags.Bool("absolute", false, ignore)
+	below := flags.Int("below", -1, ignore)
+	above := flags.Int("above", -1, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if *relative && *absolute {
+		return userError("Only one of --threshold-relative and --absolute may be specified.")
+	}
+
+	level, err := bat.readInt("capacity")
+	if err != nil {
+		panic(err)
+	}
+	if *relative {
+		if bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(bat))
+		}
+		limit, err := bat.readInt(threshold)
+		if err != nil {
+			panic(err)
+		}
+		// The level may exceed the threshold if it was lowered after
+		// the battery had already charged past it.
+		level = min(level*100/limit, 100)
+	}
+	if *absolute {
+		if bat.Capabilities()&HasHealth == 0 {
+			return userError(unsupported(bat, HasHealth))
+		}
+		// A worn battery at 100% holds less than a new one, so scale
+		// the level to the design capacity.
+		full, design, err := bat.full()
+		if err != nil {
+			panic(err)
+		}
+		level = level * full / design
+	}
+
+	// Comparisons are reported through the exit status only so that
+	// they can be used directly in shell conditionals.
+	if *below != -1 || *above != -1 {
+		if (*below != -1 && level >= *below) || (*above != -1 && level <= *above) {
+			return errFailed
+		}
+		return nil
+	}
+	fmt.Println(level)
+	return nil
+}
+
+// cmdStatus prints the charging status, explained where the battery is held
+// at its threshold.
+func cmdStatus(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	explain := flags.Bool("explain", false, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+
+	var (
+		v   string
+		err error
+	)
+	if *explain {
+		v, err = bat.explain()
+	} else {
+		v, err = bat.read("status")
+	}
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(v)
+	return nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// strict is set by --strict for scripts that need to know when a setting
+// was not applied as asked or a reading was not taken as usual.
+var strict bool
+
+// degraded notes that a fallback was taken. With --strict the program
+// exits with status 3 after printing the reason, a short hyphenated token
+// such as estimate-from-history, as "strict: reason" to stderr. Otherwise
+// the fallback stays silent.
+func degraded(reason string) {
+	if !strict {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "strict: %s\n", reason)
+	os.Exit(3)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+var errNoSysext = errors.New("systemd-sysext not found")
+
+// sysext is the system extension holding the persistence units on
+// distributions that keep /etc in read-only or transactional snapshots,
+// such as openSUSE MicroOS. systemd-sysext merges its /usr tree into the
+// running system.
+var sysext = filepath.Join("/", "var", "lib", "extensions", "bat")
+
+// release marks the directory as an extension compatible with any
+// operating system, since the version changes with every snapshot on
+// these distributions.
+const release = "ID=_any\n"
+
+// persistSysext writes the units that restore the current thresholds into
+// a system extension, enabled through the target.wants links of the
+// extension itself since /etc is left untouched, and merges it. Units
+// already in the desired state are left untouched.
+func persistSysext(ctx context.Context, units Units, batteries []*Device) ([]Outcome, error) {
+	if _, err := exec.LookPath("systemd-sysext"); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, errNoSysext
+		}
+		return nil, err
+	}
+	want, err := desired(ctx, batteries)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(sysext, "usr", "lib", "systemd", "system")
+	releases := filepath.Join(sysext, "usr", "lib", "extension-release.d")
+	for _, path := range [...]string{dir, releases} {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(releases, "extension-release.bat"), []byte(release), 0o644); err != nil {
+		return nil, err
+	}
+
+	outcomes := make([]Outcome, 0, len(events))
+	for _, event := range events {
+		service := units.name(event)
+		path := filepath.Join(dir, service)
+		wants := filepath.Join(dir, event+".target.wants")
+		link := filepath.Join(wants, service)
+
+		existing, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return outcomes, err
+		}
+		exists := err == nil
+		contents, ok := want[event]
+		if !ok {
+			if !exists {
+				continue
+			}
+			for _, p := range [...]string{link, path} {
+				if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return outcomes, err
+				}
+			}
+			outcomes = append(outcomes, Outcome{Unit: service, Action: "removed"})
+			continue
+		}
+
+		action := "unchanged"
+		if !exists || !bytes.Equal(existing, contents) {
+			if err := checkUnit("/", service, contents); err != nil {
+				return outcomes, err
+			}
+			if err := os.WriteFile(path, contents, 0o644); err != nil {
+				os.Remove(path)
+				return outcomes, err
+			}
+			action = "updated"
+			if !exists {
+				action = "created"
+			}
+		}
+		if err := os.MkdirAll(wants, 0o755); err != nil {
+			return outcomes, err
+		}
+		if err := os.Symlink(filepath.Join("..", service), link); err != nil && !errors.Is(err, fs.ErrExist) {
+			return outcomes, err
+		}
+		outcomes = append(outcomes, Outcome{Unit: service, Action: action})
+	}
+	return outcomes, merge(ctx)
+}
+
+// resetSysext removes the extension written by persistSysext, if any.
+func resetSysext(ctx context.Context) ([]Outcome, error) {
+	if _, err := os.Stat(sysext); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := os.RemoveAll(sysext); err != nil {
+		return nil, err
+	}
+	return []Outcome{{Unit: sysext, Action: "removed"}}, merge(ctx)
+}
+
+// merge refreshes the merged extensions and reloads the units so that
+// changes to the extension take effect without a restart.
+func merge(ctx context.Context) error {
+	// Unlike systemctl, systemd-sysext has no --no-ask-password flag so
+	// it is run directly rather than through command.
+	output, err := runner.Run(exec.CommandContext(ctx, "systemd-sysext", "refresh"))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%s: %w", bytes.TrimSpace(output), err)
+	}
+	_, err = systemctl(ctx, "daemon-reload")
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// errReadOnly is returned for writes to a sysfs mounted read-only, as it is
+// inside most containers.
+var errReadOnly = errors.New("sysfs is read-only")
+
+// FS is the file system through which batteries are found, read and
+// written. It is a variable so that tests can substitute a fake sysfs.
+type FS interface {
+	Glob(pattern string) ([]string, error)
+	Stat(name string) (fs.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+}
+
+// osFS is the FS of the running system.
+type osFS struct{}
+
+func (osFS) Glob(pattern string) ([]string, error) { return filepath.Glob(pattern) }
+func (osFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+func (osFS) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+
+// WriteFile writes to an existing file only since sysfs attributes cannot
+// be created, so that a missing one is reported rather than a stray file
+// left behind. Drivers reject invalid values from the write itself, so its
+// error is returned as well as that of closing the file. Unprivileged
+// users are denied permission before the mount is checked, so a denial on
+// a read-only mount is reported as errReadOnly too since sudo would not
+// help.
+func (osFS) WriteFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		if errors.Is(err, unix.EROFS) || errors.Is(err, fs.ErrPermission) && readOnly(name) {
+			return &fs.PathError{Op: "open", Path: name, Err: errReadOnly}
+		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readOnlyMessage explains errReadOnly.
+const readOnlyMessage = "Cannot change the setting because /sys is mounted read-only, as it is when\n" +
+	"running inside a container. Change it on the host instead."
+
+// readOnly reports whether the file system holding name is mounted
+// read-only.
+func readOnly(name string) bool {
+	var st unix.Statfs_t
+	return unix.Statfs(name, &st) == nil && st.Flags&unix.ST_RDONLY != 0
+}
+
+// sysfs is the FS used for batteries. Batteries of the legacy ACPI
+// interface are translated on the way.
+var sysfs FS = procFS{osFS{}}
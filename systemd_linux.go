@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	errNoThreshold    = errors.New("charging threshold setting not found")
+	errSystemdVersion = errors.New("unsupported systemd version")
+	errNoShell        = errors.New("sh not found")
+)
+
+// Units locates the persistence units: one per event, named by the prefix
+// followed by the event, in the given directory.
+type Units struct {
+	Dir, Prefix string
+}
+
+// defaultUnits are the locations used unless overridden by flags.
+var defaultUnits = Units{Dir: services, Prefix: "bat-"}
+
+// name returns the name of the unit for the event.
+func (u Units) name(event string) string {
+	return u.Prefix + event + ".service"
+}
+
+// Outcome is the action taken to converge a single unit.
+type Outcome struct {
+	Unit, Action string
+}
+
+// Runner runs external commands such as systemctl and returns their
+// combined output. It is a variable so that tests can substitute a fake.
+type Runner interface {
+	Run(cmd *exec.Cmd) ([]byte, error)
+}
+
+// execRunner is the Runner that executes commands.
+type execRunner struct{}
+
+func (execRunner) Run(cmd *exec.Cmd) ([]byte, error) {
+	return cmd.CombinedOutput()
+}
+
+// runner is the Runner used for external commands.
+var runner Runner = execRunner{}
+
+// command returns the invocation of the systemd tool (systemctl or
+// systemd-run) with the given arguments. Without a terminal polkit would
+// otherwise wait for a password that cannot be entered, hanging scripts,
+// so it is told to fail instead. The locale is fixed so that errors can
+// be recognised from the output.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if !interactive() {
+		args = append([]string{"--no-ask-password"}, args...)
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd
+}
+
+// systemctl runs systemctl with the given arguments. Authentication
+// failures are reported as unix.EACCES.
+func systemctl(ctx context.Context, args ...string) ([]byte, error) {
+	return run(ctx, "systemctl", args...)
+}
+
+// run runs the systemd tool with the given arguments, reporting
+// authentication failures as unix.EACCES.
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := runner.Run(command(ctx, name, args...))
+	if err != nil {
+		if ctx.Err() != nil {
+			return output, ctx.Err()
+		}
+		// WORKAROUND: systemd returns the generic exit code 1 for all
+		// failures, so triage using a substring search on the output.
+		if bytes.Contains(output, []byte("authentication required")) ||
+			bytes.Contains(output, []byte("Access denied")) {
+			return output, fmt.Errorf("%s: %w", bytes.TrimSpace(output), unix.EACCES)
+		}
+		return output, fmt.Errorf("%s: %w", bytes.TrimSpace(output), err)
+	}
+	return output, nil
+}
+
+// reconcile converges the persistence units to the desired state. Units
+// for the events in want are written with the given contents and enabled,
+// and those of all other events are disabled and removed. Units already
+// in the desired state are left untouched so that it is safe to rerun
+// after a partial failure.
+func reconcile(ctx context.Context, units Units, want map[string][]byte) ([]Outcome, error) {
+	outcomes := make([]Outcome, 0, len(events))
+	reload := false
+	for _, event := range events {
+		service := units.name(event)
+		path := filepath.Join(units.Dir, service)
+
+		current, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return outcomes, err
+		}
+		exists := err == nil
+		// is-enabled exits with a non-zero status for anything other than
+		// an enabled unit, so only the output is considered.
+		output, _ := runner.Run(command(ctx, "systemctl", "is-enabled", service))
+		if ctx.Err() != nil {
+			return outcomes, ctx.Err()
+		}
+		enabled := string(bytes.TrimSpace(output)) == "enabled"
+
+		contents, ok := want[event]
+		switch {
+		case ok:
+			action := "unchanged"
+			if !exists || !bytes.Equal(current, contents) {
+				if err := checkUnit("/", service, contents); err != nil {
+					return outcomes, err
+				}
+				if err := os.WriteFile(path, contents, 0o644); err != nil {
+					// Do not leave behind a partially written unit.
+					os.Remove(path)
+					return outcomes, err
+				}
+				if err := relabel(ctx, path); err != nil {
+					return outcomes, err
+				}
+				action = "updated"
+				if !exists {
+					action = "created"
+				}
+				reload = reload || enabled
+			}
+			if !enabled {
+				if _, err := systemctl(ctx, "enable", service); err != nil {
+					if !exists {
+						os.Remove(path)
+					}
+					return outcomes, err
+				}
+				if action == "unchanged" {
+					action = "enabled"
+				}
+			}
+			outcomes = append(outcomes, Outcome{Unit: service, Action: action})
+		case exists || enabled:
+			if enabled {
+				if _, err := systemctl(ctx, "disable", service); err != nil {
+					return outcomes, err
+				}
+			}
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return outcomes, err
+			}
+			outcomes = append(outcomes, Outcome{Unit: service, Action: "removed"})
+		}
+	}
+	if reload {
+		if _, err := systemctl(ctx, "daemon-reload"); err != nil {
+			return outcomes, err
+		}
+	}
+	return outcomes, nil
+}
+
+// report prints the action taken for each unit.
+func report(outcomes []Outcome) {
+	for _, outcome := range outcomes {
+		fmt.Printf("%s: %s\n", outcome.Unit, outcome.Action)
+	}
+}
+
+// current returns the thresholds of every battery that has one, so that
+// devices with differing limits are restored as they were set. Thresholds
+// held by the BIOS are preceded by its charge mode, since they are ignored
+// unless it is Custom.
+func current(batteries []*Device) ([]Setting, error) {
+	settings := make([]Setting, 0)
+	custom := false
+	for _, b := range batteries {
+		if b.Capabilities()&HasThreshold == 0 {
+			continue
+		}
+		// The start threshold, where present, is restored first since the
+		// end threshold is usually left at its default of 100 after a
+		// restart.
+		for _, variable := range [...]string{startThreshold, threshold} {
+			v, err := b.read(variable)
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+			if _, err := strconv.Atoi(v); err != nil {
+				return nil, err
+			}
+			path := b.path(variable)
+			if isDell(path) && !custom {
+				settings = append(settings, Setting{Path: filepath.Join(sysman, dellChargeMode, "current_value"), Value: "Custom"})
+				custom = true
+			}
+			settings = append(settings, Setting{Path: path, Value: v})
+		}
+	}
+	if len(settings) == 0 {
+		return nil, errNoThreshold
+	}
+	return settings, nil
+}
+
+// persist writes and enables units that restore the current thresholds
+// after each of the supported events. Systems without systemd, such as
+// those running OpenRC, get elogind sleep hooks instead.
+func persist(ctx context.Context, units Units, batteries []*Device) ([]Outcome, error) {
+	if elogind() {
+		return persistElogind(batteries)
+	}
+	want, err := desired(ctx, batteries)
+	if err != nil {
+		return nil, err
+	}
+	return reconcile(ctx, units, want)
+}
+
+// desired returns the contents of the unit that restores the current
+// thresholds for each of the events whose target exists on the system.
+func desired(ctx context.Context, batteries []*Device) (map[string][]byte, error) {
+	settings, err := current(batteries)
+	if err != nil {
+		return nil, err
+	}
+
+	// systemd 244-rc1 is the earliest version to allow restarts for
+	// oneshot services.
+	output, err := systemctl(ctx, "--version")
+	if err != nil {
+		return nil, err
+	}
+	var revision int
+	if _, err := fmt.Sscanf(string(output), "systemd %d", &revision); err != nil {
+		return nil, err
+	}
+	if revision < 244 {
+		return nil, errSystemdVersion
+	}
+
+	shell, err := exec.LookPath("sh")
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, errNoShell
+		}
+		return nil, err
+	}
+
+	// Creates services for events with defined targets (targets vary by
+	// distribution).
+	targets, err := listTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := Service{Shell: shell, Settings: settings}
+	if err := s.onAC(); err != nil {
+		return nil, err
+	}
+	return render(targets, s)
+}
+
+// render returns the contents of the unit for each of the events among
+// targets, filling in the event of s.
+func render(targets []string, s Service) (map[string][]byte, error) {
+	tmpl := template.Must(template.New("unit").Parse(unit))
+	want := make(map[string][]byte)
+	for _, target := range targets {
+		event := strings.TrimSuffix(target, ".target")
+		if !slices.Contains(events[:], event) {
+			continue
+		}
+		s.Event, s.Scheduled = event, scheduled
+		// The delay only matters after waking up.
+		s.Delay = 0
+		if event != "multi-user" {
+			s.Delay = machine().ResumeDelay
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, s); err != nil {
+			return nil, err
+		}
+		want[event] = buf.Bytes()
+	}
+	return want, nil
+}
+
+// listTargets returns the names of the targets known to systemd. The JSON
+// output of list-units is preferred since descriptions may contain
+// anything, but older versions print the plain table regardless of
+// --output or reject the flag altogether.
+func listTargets(ctx context.Context) ([]string, error) {
+	output, err := systemctl(ctx, "list-units", "--type", "target", "--all", "--plain", "--output", "json")
+	if err == nil {
+		if targets, err := parseTargets(output); err == nil {
+			return targets, nil
+		}
+		return parsePlainTargets(output), nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	output, err = systemctl(ctx, "list-units", "--type", "target", "--all", "--plain", "--no-legend")
+	if err != nil {
+		return nil, err
+	}
+	return parsePlainTargets(output), nil
+}
+
+// parseTargets returns the unit names in the JSON output of list-units.
+// The output is an array of objects on most versions but a sequence of
+// them on others, and the name has been keyed both unit and id, so each
+// is accepted and other fields are ignored.
+func parseTargets(output []byte) ([]string, error) {
+	targets := make([]string, 0)
+	add := func(fields map[string]json.RawMessage) error {
+		for _, key := range [...]string{"unit", "id"} {
+			raw, ok := fields[key]
+			if !ok {
+				continue
+			}
+			var name string
+			if err := json.Unmarshal(raw, &name); err != nil {
+				return err
+			}
+			targets = append(targets, name)
+			return nil
+		}
+		return errors.New("unit name missing from list-units output")
+	}
+	dec := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		value = bytes.TrimSpace(value)
+		if len(value) > 0 && value[0] == '[' {
+			var units []map[string]json.RawMessage
+			if err := json.Unmarshal(value, &units); err != nil {
+				return nil, err
+			}
+			for _, fields := range units {
+				if err := add(fields); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(value, &fields); err != nil {
+			return nil, err
+		}
+		if err := add(fields); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// parsePlainTargets returns the unit names in the plain output of
+// list-units, which are in the first column. Lines that do not name a
+// target, such as the legend, are skipped.
+func parsePlainTargets(output []byte) []string {
+	targets := make([]string, 0)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		// Failed units are marked with a bullet before the name.
+		if len(fields) > 1 && fields[0] == "●" {
+			fields = fields[1:]
+		}
+		if len(fields) > 0 && strings.HasSuffix(fields[0], ".target") {
+			targets = append(targets, fields[0])
+		}
+	}
+	return targets
+}
+
+// repersist rewrites the persistence units and the verification unit, if
+// any are installed, so that they restore and expect the thresholds just
+// set rather than stale ones. It reports whether persistence was enabled.
+func repersist(ctx context.Context, units Units, batteries []*Device) (bool, []Outcome, error) {
+	if _, err := os.Stat(sysext); err == nil {
+		outcomes, err := persistSysext(ctx, units, batteries)
+		return true, outcomes, err
+	}
+	if elogind() {
+		if _, err := os.Stat(filepath.Join(hooks, "bat")); err != nil {
+			return false, nil, nil
+		}
+		outcomes, err := persistElogind(batteries)
+		return true, outcomes, err
+	}
+	// The verification unit is kept up to date even if nothing restores
+	// the thresholds.
+	verified, err := updateVerifier(ctx, units, batteries)
+	if err != nil {
+		return false, verified, err
+	}
+	for _, event := range events {
+		if _, err := os.Stat(filepath.Join(units.Dir, units.name(event))); err == nil {
+			outcomes, err := persist(ctx, units, batteries)
+			return true, append(verified, outcomes...), err
+		}
+	}
+	return false, verified, nil
+}
+
+// apply starts one of the units written by persist so that the threshold
+// is applied by the same mechanism as after a restart, confirming that it
+// works. It returns the name of the unit.
+func apply(ctx context.Context, outcomes []Outcome) (string, error) {
+	for _, outcome := range outcomes {
+		if outcome.Action == "removed" {
+			continue
+		}
+		// The units remain active after they exit so they need to be
+		// restarted to run again.
+		_, err := systemctl(ctx, "restart", outcome.Unit)
+		return outcome.Unit, err
+	}
+	return "", errors.New("no units to apply")
+}
+
+// cmdPersist installs the units that restore the thresholds after restarts
+// and resumes.
+func cmdPersist(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("persist", flag.ExitOnError)
+	now := flags.Bool("now", false, ignore)
+	method := flags.String("method", "auto", ignore)
+	offline := flags.Bool("offline", false, ignore)
+	root := flags.String("root", "/", ignore)
+	units := defaultUnits
+	flags.StringVar(&units.Dir, "unit-dir", units.Dir, ignore)
+	flags.StringVar(&units.Prefix, "unit-prefix", units.Prefix, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if *method != "auto" && *method != "sysext" {
+		return userError("Method should be one of `auto` or `sysext`.")
+	}
+	if *offline && (*now || *method != "auto") || !*offline && *root != "/" {
+		return userError("The --root flag requires --offline, which cannot be combined with --now\n" +
+			"or --method.")
+	}
+
+	var (
+		outcomes []Outcome
+		err      error
+	)
+	if *offline {
+		outcomes, err = persistOffline(*root, units, batteries)
+		report(outcomes)
+		if errors.Is(err, errNoTargets) {
+			return userError(fmt.Sprintf("None of the supported targets are installed under %s.", *root))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("Persistence of the current charging threshold enabled. It takes effect\n" +
+			"after the next boot of the system.")
+		return nil
+	}
+	if *method == "sysext" {
+		outcomes, err = persistSysext(ctx, units, batteries)
+	} else {
+		outcomes, err = persist(ctx, units, batteries)
+	}
+	report(outcomes)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Persistence of the current charging threshold enabled.")
+	if *now && elogind() {
+		if err := applyElogind(ctx); err != nil {
+			return err
+		}
+		fmt.Println("Charging threshold applied by the elogind sleep hook.")
+		return nil
+	}
+	if *now {
+		unit, err := apply(ctx, outcomes)
+		if unit == "" {
+			return userError("None of the supported targets are available.")
+		}
+		if err != nil && ctx.Err() == nil && !errors.Is(err, unix.EACCES) {
+			return userError(fmt.Sprintf("Failed to start %s. Run `journalctl -u %[1]s` for details.", unit))
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Charging threshold applied by %s.\n", unit)
+	}
+	return nil
+}
+
+// cmdReset removes the persistence units and the rules and units installed
+// with them.
+func cmdReset(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("reset", flag.ExitOnError)
+	units := defaultUnits
+	flags.StringVar(&units.Dir, "unit-dir", units.Dir, ignore)
+	flags.StringVar(&units.Prefix, "unit-prefix", units.Prefix, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+
+	removed, err := removeACOnly(ctx)
+	if removed {
+		report([]Outcome{{Unit: acRules, Action: "removed"}})
+	}
+	if err != nil {
+		return err
+	}
+	if elogind() {
+		outcomes, err := resetElogind()
+		report(outcomes)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Charging threshold persistence reset.")
+		return nil
+	}
+	outcomes, err := reconcile(ctx, units, nil)
+	report(outcomes)
+	if err != nil {
+		return err
+	}
+	outcomes, err = resetSysext(ctx)
+	report(outcomes)
+	if err != nil {
+		return err
+	}
+	removed, err = removeVerifier(ctx, units)
+	if removed {
+		report([]Outcome{{Unit: units.verifier(), Action: "removed"}})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Charging threshold persistence reset.")
+	return nil
+}
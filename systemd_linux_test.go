@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// targets are the names listed in each file under testdata/list-units.
+var targets = []string{"basic.target", "hibernate.target", "multi-user.target", "suspend.target"}
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		file    string
+		wantErr bool
+	}{
+		// Most versions print an array of objects keyed unit.
+		{file: "array.json"},
+		// Some versions print one object per line.
+		{file: "stream.json"},
+		{file: "id.json"},
+		{file: "missing.json", wantErr: true},
+		// systemd 244 to 256 ignore --output and print the plain table,
+		// which is left to parsePlainTargets.
+		{file: "plain.txt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join("testdata", "list-units", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseTargets(output)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseTargets = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTargets: %v", err)
+			}
+			if !slices.Equal(got, targets) {
+				t.Errorf("parseTargets = %q, want %q", got, targets)
+			}
+		})
+	}
+}
+
+func TestParsePlainTargets(t *testing.T) {
+	for _, file := range [...]string{"plain.txt", "no-legend.txt"} {
+		t.Run(file, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join("testdata", "list-units", file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := parsePlainTargets(output); !slices.Equal(got, targets) {
+				t.Errorf("parsePlainTargets = %q, want %q", got, targets)
+			}
+		})
+	}
+}
+
+// reply is the output and error of a command run by fakeRunner.
+type reply struct {
+	output string
+	err    error
+}
+
+// fakeRunner records the commands it is given and answers them from
+// replies, keyed by the command line without --no-ask-password. Commands
+// without a reply fail as systemctl does for an unknown verb.
+type fakeRunner struct {
+	replies map[string]reply
+	calls   []string
+}
+
+func (r *fakeRunner) Run(cmd *exec.Cmd) ([]byte, error) {
+	args := slices.DeleteFunc(slices.Clone(cmd.Args), func(arg string) bool { return arg == "--no-ask-password" })
+	call := strings.Join(args, " ")
+	r.calls = append(r.calls, call)
+	reply, ok := r.replies[call]
+	if !ok {
+		return []byte("Unknown command verb " + args[len(args)-1] + ".\n"), errors.New("exit status 1")
+	}
+	return []byte(reply.output), reply.err
+}
+
+// useRunner substitutes r for runner until the end of the test.
+func useRunner(t *testing.T, r Runner) {
+	t.Helper()
+	saved := runner
+	runner = r
+	t.Cleanup(func() { runner = saved })
+}
+
+func TestCommand(t *testing.T) {
+	cmd := command(context.Background(), "systemctl", "enable", "bat-suspend.service")
+	want := []string{"systemctl", "enable", "bat-suspend.service"}
+	if !interactive() {
+		want = slices.Insert(want, 1, "--no-ask-password")
+	}
+	if !slices.Equal(cmd.Args, want) {
+		t.Errorf("Args = %q, want %q", cmd.Args, want)
+	}
+	// The last value of a variable is the one that applies, whatever the
+	// locale of the caller.
+	locale := ""
+	for _, v := range cmd.Env {
+		if strings.HasPrefix(v, "LC_ALL=") {
+			locale = v
+		}
+	}
+	if locale != "LC_ALL=C" {
+		t.Errorf("Env sets %q, want LC_ALL=C", locale)
+	}
+}
+
+func TestSystemctlErrors(t *testing.T) {
+	failed := errors.New("exit status 1")
+	tests := []struct {
+		name   string
+		output string
+		want   error
+	}{
+		{name: "polkit", output: "Failed to enable unit: Access denied\n", want: unix.EACCES},
+		{name: "no agent", output: "Failed to enable unit: Interactive authentication required.\n", want: unix.EACCES},
+		{name: "other", output: "Failed to enable unit: Unit file bat-suspend.service does not exist.\n", want: failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRunner(t, &fakeRunner{replies: map[string]reply{
+				"systemctl enable bat-suspend.service": {tt.output, failed},
+			}})
+			_, err := systemctl(context.Background(), "enable", "bat-suspend.service")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("systemctl = %v, want %v", err, tt.want)
+			}
+			if !strings.Contains(err.Error(), strings.TrimSpace(tt.output)) {
+				t.Errorf("systemctl = %v, want the output of systemctl", err)
+			}
+		})
+	}
+}
+
+func TestListTargets(t *testing.T) {
+	const (
+		json  = "systemctl list-units --type target --all --plain --output json"
+		plain = "systemctl list-units --type target --all --plain --no-legend"
+	)
+	read := func(file string) string {
+		contents, err := os.ReadFile(filepath.Join("testdata", "list-units", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(contents)
+	}
+	failed := errors.New("exit status 1")
+	tests := []struct {
+		name    string
+		replies map[string]reply
+		wantErr bool
+	}{
+		{name: "json", replies: map[string]reply{json: {output: read("array.json")}}},
+		{name: "output ignored", replies: map[string]reply{json: {output: read("plain.txt")}}},
+		{name: "output rejected", replies: map[string]reply{
+			json:  {"systemctl: unrecognized option '--output'\n", failed},
+			plain: {output: read("no-legend.txt")},
+		}},
+		{name: "failed", replies: map[string]reply{
+			json:  {"System has not been booted with systemd as init system (PID 1). Can't operate.\n", failed},
+			plain: {"System has not been booted with systemd as init system (PID 1). Can't operate.\n", failed},
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRunner(t, &fakeRunner{replies: tt.replies})
+			got, err := listTargets(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("listTargets = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listTargets: %v", err)
+			}
+			if !slices.Equal(got, targets) {
+				t.Errorf("listTargets = %q, want %q", got, targets)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	units := Units{Dir: t.TempDir(), Prefix: "bat-"}
+	service := units.name("suspend")
+	path := filepath.Join(units.Dir, service)
+	contents := []byte("[Unit]\nDescription=Test\n\n[Service]\nType=oneshot\nExecStart=/bin/sh -c 'true'\n\n[Install]\nWantedBy=suspend.target\n")
+	want := map[string][]byte{"suspend": contents}
+	ctx := context.Background()
+
+	r := &fakeRunner{replies: map[string]reply{
+		"systemctl enable " + service: {output: "Created symlink.\n"},
+	}}
+	useRunner(t, r)
+	outcomes, err := reconcile(ctx, units, want)
+	if err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if !slices.Equal(outcomes, []Outcome{{service, "created"}}) {
+		t.Errorf("outcomes = %v, want %s created", outcomes, service)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, contents) {
+		t.Errorf("%s = %q, want %q", service, got, contents)
+	}
+	if !slices.Contains(r.calls, "systemctl enable "+service) {
+		t.Errorf("calls = %q, want %s enabled", r.calls, service)
+	}
+
+	// Rerunning leaves the enabled unit alone.
+	r = &fakeRunner{replies: map[string]reply{
+		"systemctl is-enabled " + service: {output: "enabled\n"},
+	}}
+	useRunner(t, r)
+	if outcomes, err = reconcile(ctx, units, want); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if !slices.Equal(outcomes, []Outcome{{service, "unchanged"}}) {
+		t.Errorf("outcomes = %v, want %s unchanged", outcomes, service)
+	}
+	for _, call := range r.calls {
+		if !strings.HasPrefix(call, "systemctl is-enabled ") {
+			t.Errorf("unexpected call %q", call)
+		}
+	}
+
+	r = &fakeRunner{replies: map[string]reply{
+		"systemctl is-enabled " + service: {output: "enabled\n"},
+		"systemctl disable " + service:    {output: "Removed symlink.\n"},
+	}}
+	useRunner(t, r)
+	if outcomes, err = reconcile(ctx, units, nil); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if !slices.Equal(outcomes, []Outcome{{service, "removed"}}) {
+		t.Errorf("outcomes = %v, want %s removed", outcomes, service)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%s was not removed", path)
+	}
+}
+
+func TestReconcileDenied(t *testing.T) {
+	units := Units{Dir: t.TempDir(), Prefix: "bat-"}
+	service := units.name("suspend")
+	contents := []byte("[Unit]\nDescription=Test\n\n[Service]\nType=oneshot\nExecStart=/bin/sh -c 'true'\n")
+	useRunner(t, &fakeRunner{replies: map[string]reply{
+		"systemctl enable " + service: {"Failed to enable unit: Access denied\n", errors.New("exit status 1")},
+	}})
+	_, err := reconcile(context.Background(), units, map[string][]byte{"suspend": contents})
+	if !errors.Is(err, unix.EACCES) {
+		t.Errorf("reconcile = %v, want %v", err, unix.EACCES)
+	}
+	// A unit that could not be enabled is not left behind.
+	if _, err := os.Stat(filepath.Join(units.Dir, service)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("%s was left behind", service)
+	}
+}
+
+func TestCurrentDell(t *testing.T) {
+	f, b := newFakeBattery(map[string]string{"type": "Battery"})
+	mode := filepath.Join(sysman, dellChargeMode, "current_value")
+	stop := filepath.Join(sysman, dellSettings[threshold], "current_value")
+	start := filepath.Join(sysman, dellSettings[startThreshold], "current_value")
+	for path, v := range map[string]string{mode: "Custom", stop: "80", start: "75"} {
+		f.files[strings.TrimPrefix(path, "/")] = &fstest.MapFile{Data: []byte(v + "\n")}
+	}
+	useFS(t, f)
+	d := &Device{battery: *b, Name: "BAT0", capabilities: HasThreshold | HasStartThreshold}
+	got, err := current([]*Device{d})
+	if err != nil {
+		t.Fatalf("current: %v", err)
+	}
+	// The charge mode comes first since the BIOS ignores the thresholds
+	// otherwise.
+	want := []Setting{{mode, "Custom"}, {start, "75"}, {stop, "80"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("current = %v, want %v", got, want)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	dir := t.TempDir()
+	savedServices, savedScheduled := services, scheduled
+	services, scheduled = dir, filepath.Join(dir, "full-charge")
+	t.Cleanup(func() { services, scheduled = savedServices, savedScheduled })
+	f, b := newFakeBattery(map[string]string{threshold: "80"})
+	useFS(t, f)
+	d := &Device{battery: *b, Name: "BAT0", capabilities: HasThreshold}
+	r := &fakeRunner{replies: map[string]reply{
+		"systemctl daemon-reload":                              {},
+		"systemctl enable --now bat-full-charge-BAT0.timer":    {},
+		"systemctl enable --now bat-restore-charge-BAT0.timer": {},
+	}}
+	useRunner(t, r)
+	ctx := context.Background()
+	at := time.Date(2024, time.July, 1, 6, 0, 0, 0, time.Local)
+	if err := schedule(ctx, d, at, 12*time.Hour); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	for unit, want := range map[string]string{
+		"bat-full-charge-BAT0.timer":      "OnCalendar=2024-07-01 06:00:00\n",
+		"bat-full-charge-BAT0.service":    "echo 100 > /sys/class/power_supply/BAT0/" + threshold,
+		"bat-restore-charge-BAT0.timer":   "OnCalendar=2024-07-01 18:00:00\n",
+		"bat-restore-charge-BAT0.service": "echo 80 > /sys/class/power_supply/BAT0/" + threshold,
+	} {
+		contents, err := os.ReadFile(filepath.Join(dir, unit))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(contents), want) {
+			t.Errorf("%s = %q, want it to contain %q", unit, contents, want)
+		}
+	}
+
+	r.replies = map[string]reply{
+		"systemctl disable --now bat-full-charge-BAT0.timer":    {},
+		"systemctl disable --now bat-restore-charge-BAT0.timer": {},
+	}
+	removed, err := unschedule(ctx, d.Name)
+	if err != nil {
+		t.Fatalf("unschedule: %v", err)
+	}
+	if want := []string{"bat-full-charge-BAT0.timer", "bat-restore-charge-BAT0.timer"}; !slices.Equal(removed, want) {
+		t.Errorf("unschedule = %q, want %q", removed, want)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "bat-*")); len(matches) != 0 {
+		t.Errorf("unschedule left %q behind", matches)
+	}
+}
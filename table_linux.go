@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+// Table renders rows of cells in aligned columns. When written to a
+// terminal the header is shown in bold, unless NO_COLOR is set, and rows
+// are truncated to the width of the terminal.
+type Table struct {
+	Header []string
+	// Right lists the indices of columns aligned to the right, such as
+	// those holding numbers.
+	Right []int
+	rows  [][]string
+}
+
+// Append adds a row to the table.
+func (t *Table) Append(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// Render writes the table to w.
+func (t *Table) Render(w io.Writer) error {
+	const gap = 2
+	widths := make([]int, len(t.Header))
+	for _, row := range append([][]string{t.Header}, t.rows...) {
+		for i, cell := range row {
+			if i < len(widths) {
+				widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+			}
+		}
+	}
+
+	limit, bold := 0, false
+	if f, ok := w.(*os.File); ok {
+		if ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ); err == nil {
+			limit, bold = int(ws.Col), os.Getenv("NO_COLOR") == ""
+		}
+	}
+
+	var b strings.Builder
+	line := func(row []string, header bool) {
+		var l strings.Builder
+		for i, cell := range row {
+			if i >= len(widths) {
+				break
+			}
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			if i > 0 {
+				l.WriteString(strings.Repeat(" ", gap))
+			}
+			if slices.Contains(t.Right, i) {
+				l.WriteString(pad + cell)
+			} else if i < len(row)-1 {
+				l.WriteString(cell + pad)
+			} else {
+				l.WriteString(cell)
+			}
+		}
+		s := l.String()
+		if limit > 0 && utf8.RuneCountInString(s) > limit {
+			s = string([]rune(s)[:limit-1]) + "…"
+		}
+		if header && bold {
+			s = "\x1b[1m" + s + "\x1b[0m"
+		}
+		b.WriteString(s + "\n")
+	}
+	line(t.Header, true)
+	for _, row := range t.rows {
+		line(row, false)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// cooldown is how far below the maximum charging temperature the battery
+// must cool before charging resumes, so that it does not toggle around
+// the limit.
+const cooldown = 5
+
+// temperature returns the temperature of the battery in degrees Celsius.
+// The kernel reports it in tenths of a degree.
+func (b *battery) temperature() (float64, error) {
+	tenths, err := b.readInt("temp")
+	if err != nil {
+		return 0, err
+	}
+	return float64(tenths) / 10, nil
+}
+
+// resume lets the batteries paused by cool charge again, so that they
+// are not left paused when the daemon stops.
+func (d *daemon) resume() {
+	for name := range d.hot {
+		if b, ok := device(d.devices(), name); ok {
+			if err := b.write("charge_behaviour", []byte("auto")); err != nil {
+				d.log.Error("resuming charging failed", "device", name, "operation", "thermal", "error", err)
+			}
+		}
+	}
+}
+
+// cool warns about batteries charging above the maximum temperature and,
+// if configured, pauses their charging through charge_behaviour until
+// they have cooled. It does nothing unless a maximum is configured.
+func (d *daemon) cool(states []State) error {
+	if d.maxTemp == 0 {
+		return nil
+	}
+	if d.hot == nil {
+		d.hot = make(map[string]bool)
+	}
+	for _, s := range states {
+		b, ok := device(d.devices(), s.Battery)
+		if !ok || s.Status == removed || b.Capabilities()&HasTemperature == 0 {
+			continue
+		}
+		temp, err := b.temperature()
+		if err != nil {
+			return err
+		}
+		switch {
+		case !d.hot[b.Name] && temp >= float64(d.maxTemp) && s.Status == "Charging":
+			d.log.Warn("charging while hot", "device", b.Name, "operation", "thermal",
+				"value", temp, "limit", d.maxTemp)
+			if !d.pauseHot || b.Capabilities()&HasChargeBehaviour == 0 {
+				continue
+			}
+			if err := b.write("charge_behaviour", []byte("inhibit-charge")); err != nil {
+				return err
+			}
+			d.hot[b.Name] = true
+			d.log.Info("paused charging", "device", b.Name, "operation", "thermal", "value", temp)
+		case d.hot[b.Name] && temp <= float64(d.maxTemp-cooldown):
+			if err := b.write("charge_behaviour", []byte("auto")); err != nil {
+				return err
+			}
+			delete(d.hot, b.Name)
+			d.log.Info("resumed charging", "device", b.Name, "operation", "thermal", "value", temp)
+		}
+	}
+	return nil
+}
+
+// cmdTemperature prints the temperature of the battery.
+func cmdTemperature(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	if bat.Capabilities()&HasTemperature == 0 {
+		return userError(unsupported(bat, HasTemperature))
+	}
+	temp, err := bat.temperature()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(numbers.Celsius(temp))
+	return nil
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const startThreshold = "charge_control_start_threshold"
+
+var (
+	errThresholdOrder = errors.New("start threshold must be below end threshold")
+	errNotApplied     = errors.New("setting not applied")
+)
+
+// set writes the value to the variable and reads it back. Some drivers
+// accept a write but ignore or round the value, in which case
+// errNotApplied is returned.
+func (b *battery) set(variable string, value int) error {
+	if err := b.write(variable, []byte(strconv.Itoa(value))); err != nil {
+		return err
+	}
+	return b.verify(variable, value)
+}
+
+// verify returns errNotApplied if the variable does not hold the value.
+func (b *battery) verify(variable string, value int) error {
+	v, err := b.read(variable)
+	if err != nil {
+		return err
+	}
+	if v != strconv.Itoa(value) {
+		return fmt.Errorf("%s is %s rather than %d: %w", variable, v, value, errNotApplied)
+	}
+	return nil
+}
+
+// setThresholds sets the start and end charging thresholds together. The
+// kernel rejects a start threshold above the current end threshold (and
+// vice versa) with EINVAL, so the writes are ordered such that the pair is
+// valid after each of them.
+func (b *battery) setThresholds(start, end int) error {
+	if start >= end {
+		return errThresholdOrder
+	}
+	current, err := b.readInt(threshold)
+	if err != nil {
+		return err
+	}
+	writes := [...]struct {
+		variable string
+		value    int
+	}{{startThreshold, start}, {threshold, end}}
+	// Raising the start threshold above the current end would be rejected,
+	// so move the end first.
+	if start >= current {
+		writes[0], writes[1] = writes[1], writes[0]
+	}
+	for _, w := range writes {
+		if err := b.set(w.variable, w.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cmdThreshold prints or sets the charging threshold.
+func cmdThreshold(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("threshold", flag.ExitOnError)
+	each := flags.String("each", "", ignore)
+	ask := flags.Bool("ask", false, ignore)
+	slider := flags.Bool("slider", false, ignore)
+	start := flags.Int("start", -1, ignore)
+	end := flags.Int("end", -1, ignore)
+	verifyAfterResume := flags.Bool("verify-after-resume", false, ignore)
+	storage := flags.Int("when-full-discharge-to", -1, ignore)
+	listSupported := flags.Bool("list-supported", false, ignore)
+	verbose := flags.Bool("verbose", false, ignore)
+	onACOnly := flags.Bool("on-ac-only", false, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+
+	if *listSupported {
+		if flags.NFlag() != 1 || flags.NArg() != 0 {
+			return userError("The `--list-supported` flag does not take any arguments.")
+		}
+		if bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(bat))
+		}
+		fmt.Println("Trying each threshold. The current one is restored afterwards.")
+		values, err := bat.probe(ctx)
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			fmt.Println("The device did not accept any threshold.")
+			return nil
+		}
+		fmt.Printf("Accepted thresholds: %s.\n", ranges(values))
+		if err := saveProbed(values); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if *storage != -1 {
+		if flags.NFlag() != 1 || flags.NArg() != 0 {
+			return userError("The `--when-full-discharge-to` flag should be used on its own.")
+		}
+		if bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(bat))
+		}
+		if *storage < 1 || *storage > 100 {
+			return userError("Threshold value should be between 1 and 100.")
+		}
+		if q, ok := machineQuirk(); ok && !slices.Contains(q.Values, *storage) {
+			return userError(q.message(*storage))
+		}
+		if err := bat.set(threshold, *storage); err != nil {
+			return err
+		}
+		audit(bat.Name, *storage)
+		fmt.Println("Charging threshold set.")
+
+		// Lowering the threshold does not drain a battery that is
+		// already above it, which is left sitting at that level on AC
+		// power unless it is discharged.
+		level, err := bat.readInt("capacity")
+		if err != nil {
+			panic(err)
+		}
+		srcs, err := sources()
+		if err != nil {
+			panic(err)
+		}
+		if level <= *storage || online(srcs) == "" {
+			return nil
+		}
+		ok, err := bat.canForceDischarge()
+		if err != nil {
+			panic(err)
+		}
+		if !ok {
+			fmt.Printf("The battery is at %d%% and cannot be discharged on external power on\n"+
+				"this device. Unplug it to bring it down to %d%%.\n", level, *storage)
+			return nil
+		}
+		fmt.Printf("Discharging from %d%% to %d%%. Press Ctrl+C to stop.\n", level, *storage)
+		last := level
+		err = bat.dischargeTo(ctx, *storage, time.Minute, func(level int) {
+			if level != last {
+				fmt.Printf("%d%%\n", level)
+				last = level
+			}
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Discharged. The battery is now held at the threshold.")
+		return nil
+	}
+
+	if *verifyAfterResume {
+		if flags.NFlag() != 1 || flags.NArg() != 0 {
+			return userError("The `--verify-after-resume` flag does not take any arguments.")
+		}
+		if err := installVerifier(ctx, defaultUnits, batteries); err != nil {
+			return err
+		}
+		fmt.Println("The charging threshold will be verified after every resume. Warnings are\n" +
+			"logged to the journal.")
+		return nil
+	}
+
+	if *onACOnly {
+		if flags.NFlag() != 1 || flags.NArg() != 1 {
+			return userError("The `--on-ac-only` flag should be used with a threshold only.")
+		}
+		if bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(bat))
+		}
+		value, err := strconv.Atoi(flags.Arg(0))
+		if err != nil || value < 1 || value > 100 {
+			return userError("Threshold value should be between 1 and 100.")
+		}
+		if q, ok := machineQuirk(); ok && !slices.Contains(q.Values, value) {
+			return userError(q.message(value))
+		}
+		if err := installACOnly(ctx, batteries, value); err != nil {
+			return err
+		}
+		for _, b := range batteries {
+			if b.Capabilities()&HasThreshold != 0 {
+				audit(b.Name, value)
+			}
+		}
+		fmt.Printf("Charging threshold set to %d%% on external power and 100%% on battery.\n", value)
+		if !updatePersisted(ctx, batteries) {
+			fmt.Println("Run `sudo bat persist` to persist the setting between restarts.")
+		}
+		return nil
+	}
+
+	if *start != -1 {
+		if *end == -1 || *ask || *slider || *each != "" || flags.NArg() != 0 {
+			return userError("The `--start` flag should be used together with `--end` only.")
+		}
+		if bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(bat))
+		}
+		if bat.Capabilities()&HasStartThreshold == 0 {
+			return userError(unsupported(bat, HasStartThreshold))
+		}
+		if *start < 0 || *start > 100 || *end < 1 || *end > 100 {
+			return userError("Threshold value should be between 1 and 100.")
+		}
+		if q, ok := machineQuirk(); ok && !slices.Contains(q.Values, *end) {
+			return userError(q.message(*end))
+		}
+		if err := bat.setThresholds(*start, *end); err != nil {
+			if errors.Is(err, errThresholdOrder) {
+				return userError("The start threshold should be below the end threshold.")
+			}
+			if err != nil {
+				return err
+			}
+		}
+		audit(bat.Name, *end)
+		fmt.Println("Charging thresholds set.")
+		if !updatePersisted(ctx, batteries) {
+			fmt.Println("Run `sudo bat persist` to persist the settings between restarts.")
+		}
+		return nil
+	}
+	// --end on its own is the same as passing the value as an argument.
+	args = flags.Args()
+	if *end != -1 {
+		args = append(args, strconv.Itoa(*end))
+	}
+
+	// Pairs each battery to be set with its new threshold.
+	type assignment struct {
+		bat     *Device
+		setting string
+	}
+	assignments := make([]assignment, 0)
+	switch {
+	case *ask && *each == "" && len(args) == 0:
+		if bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(bat))
+		}
+		v, err := bat.read(threshold)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("The current charging threshold is %s%%. A threshold of %d%% is\n"+
+			"recommended for laptops that are mostly plugged in.\n", v, recommended)
+		q, quirky := machineQuirk()
+		setting := prompt("New threshold (1-100): ", func(answer string) bool {
+			i, err := strconv.Atoi(answer)
+			if err != nil || i < 1 || i > 100 {
+				fmt.Println("Threshold value should be an integer between 1 and 100.")
+				return false
+			}
+			if quirky && !slices.Contains(q.Values, i) {
+				fmt.Println(q.message(i))
+				return false
+			}
+			return true
+		})
+		if !confirm(fmt.Sprintf("Set the charging threshold to %s%%?", setting)) {
+			return nil
+		}
+		assignments = append(assignments, assignment{bat: bat, setting: setting})
+	case *ask:
+		return userError("The `--ask` flag does not take any arguments.")
+	case *slider && *each == "" && len(args) == 0:
+		if bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(bat))
+		}
+		if !interactive() {
+			return userError("The slider needs a terminal.")
+		}
+		v, err := bat.readInt(threshold)
+		if err != nil {
+			panic(err)
+		}
+		values := make([]int, 0, 100)
+		for i := 1; i <= 100; i++ {
+			values = append(values, i)
+		}
+		if q, ok := machineQuirk(); ok {
+			values = q.Values
+		}
+		// Each value is set as the command line would, falling back to
+		// the helper without permission, and read back so that what the
+		// device holds is shown.
+		changed, err := slide(ctx, os.Stdin, v, values, func(value int) (int, string) {
+			err := bat.set(threshold, value)
+			delegated := errors.Is(err, unix.EACCES)
+			if delegated {
+				err = delegate(ctx, bat.Name, strconv.Itoa(value))
+				// Without the helper polkit is asked instead, where
+				// pkexec is installed.
+				if errors.Is(err, fs.ErrNotExist) || errors.Is(err, unix.ECONNREFUSED) {
+					if perr := elevate(ctx, bat.Name, strconv.Itoa(value)); !errors.Is(perr, exec.ErrNotFound) {
+						err = perr
+					}
+				}
+			}
+			switch {
+			case errors.Is(err, fs.ErrNotExist), errors.Is(err, unix.EACCES), errors.Is(err, unix.ECONNREFUSED):
+				return 0, "Permission denied. Install the helper with `sudo bat helper install group`."
+			case errors.Is(err, unix.EINVAL):
+				return 0, "The device rejected the setting. It may only accept certain values."
+			case errors.Is(err, errNotApplied):
+				// The value read back shows what the device holds instead.
+			case err != nil:
+				return 0, err.Error()
+			case !delegated:
+				audit(bat.Name, value)
+			}
+			v, err := bat.readInt(threshold)
+			if err != nil {
+				return 0, err.Error()
+			}
+			return v, ""
+		})
+		if !changed {
+			if err != nil {
+				return err
+			}
+			return nil
+		}
+		if err != nil && ctx.Err() == nil {
+			panic(err)
+		}
+	case *slider:
+		return userError("The `--slider` flag does not take any arguments.")
+	case *each != "" && len(args) == 0:
+		for _, pair := range strings.Split(*each, ",") {
+			name, setting, ok := strings.Cut(pair, "=")
+			if !ok {
+				return userError("Assignments should be of the form `BAT0=80,BAT1=90`.")
+			}
+			d, ok := device(batteries, name)
+			if !ok {
+				return userError(fmt.Sprintf("There is no `%s` battery.", name))
+			}
+			assignments = append(assignments, assignment{bat: d, setting: setting})
+		}
+	case *each == "" && len(args) == 0:
+		// Get.
+		if bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(bat))
+		}
+		v, err := bat.read(threshold)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(v)
+		if !*verbose {
+			return nil
+		}
+		cs, err := loadChanges()
+		if err != nil {
+			panic(err)
+		}
+		c, ok := cs[bat.Name]
+		if !ok {
+			fmt.Println("No changes have been recorded.")
+			return nil
+		}
+		by := strconv.Itoa(c.UID)
+		if u, err := user.LookupId(by); err == nil {
+			by = fmt.Sprintf("%s (%d)", u.Username, c.UID)
+		}
+		times := "times"
+		if c.Count == 1 {
+			times = "time"
+		}
+		fmt.Printf("Changed %d %s through bat, last to %d%% at %s by %s.\n",
+			c.Count, times, c.Value, c.Time.Format("2006-01-02 15:04"), by)
+		return nil
+	case *each == "" && len(args) == 1:
+		assignments = append(assignments, assignment{bat: bat, setting: args[0]})
+	default:
+		return errUsage
+	}
+
+	// Set.
+	// The earliest version of the Linux kernel to expose the battery
+	// charging threshold is 5.4.
+	var utsname unix.Utsname
+	if err := unix.Uname(&utsname); err != nil {
+		panic(err)
+	}
+	var maj, min int
+	_, err := fmt.Sscanf(string(utsname.Release[:]), "%d.%d", &maj, &min)
+	if err != nil {
+		panic(err)
+	}
+	if maj <= 5 && (maj != 5 || min < 4) {
+		return userError("Requires Linux kernel version 5.4 or later.")
+	}
+
+	// Validate every assignment before writing any of them. Some drivers
+	// accept only a few values and silently ignore the rest, so those
+	// are checked up front.
+	q, quirky := machineQuirk()
+	for _, a := range assignments {
+		if a.bat.Capabilities()&HasThreshold == 0 {
+			return userError(missing(a.bat))
+		}
+		i, err := strconv.Atoi(a.setting)
+		if err != nil {
+			if errors.Is(err, strconv.ErrSyntax) {
+				return userError("Argument should be an integer.")
+			}
+			panic(err)
+		}
+		if i < 1 || i > 100 {
+			return userError("Threshold value should be between 1 and 100.")
+		}
+		if quirky && !slices.Contains(q.Values, i) {
+			return userError(q.message(i))
+		}
+	}
+	for _, a := range assignments {
+		// Validated above.
+		value, _ := strconv.Atoi(a.setting)
+		err := a.bat.set(threshold, value)
+		// The helper records the change itself.
+		delegated := errors.Is(err, unix.EACCES)
+		if delegated {
+			// Fall back to the helper if it has been installed.
+			err = delegate(ctx, a.bat.Name, a.setting)
+			// Without the helper, the permission the user lacks is what
+			// is reported.
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, unix.ECONNREFUSED) {
+				return unix.EACCES
+			}
+			if err != nil {
+				return err
+			}
+			err = a.bat.verify(threshold, value)
+		}
+		if err != nil {
+			return err
+		}
+		if !delegated {
+			audit(a.bat.Name, value)
+		}
+	}
+	fmt.Println("Charging threshold set.")
+	// A threshold set explicitly replaces one applied only on external
+	// power, which the rule would otherwise restore at the next change
+	// of source.
+	removed, err := removeACOnly(ctx)
+	if errors.Is(err, fs.ErrPermission) {
+		fmt.Println("Run `sudo bat reset` to stop raising the threshold on battery.")
+	} else {
+		if err != nil {
+			return err
+		}
+	}
+	if removed {
+		fmt.Println("The threshold now also applies on battery.")
+	}
+	if updatePersisted(ctx, batteries) {
+		return nil
+	}
+	if !*ask {
+		fmt.Println("Run `sudo bat persist` to persist the setting between restarts.")
+		return nil
+	}
+	if confirm("Persist the setting between restarts?") {
+		outcomes, err := persist(ctx, defaultUnits, batteries)
+		report(outcomes)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Persistence of the current charging threshold enabled.")
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Consumer is a process and its share of CPU time over a sampling window.
+type Consumer struct {
+	PID     int
+	Command string
+	Ticks   uint64
+}
+
+// ticks returns the user and system CPU time consumed by each running
+// process, keyed by process ID.
+func ticks() (map[int]Consumer, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	consumers := make(map[int]Consumer, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			// The process may have exited in the meantime.
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, unix.ESRCH) {
+				continue
+			}
+			return nil, err
+		}
+		// The command name is enclosed in parentheses and may itself
+		// contain spaces or parentheses.
+		i, j := bytes.IndexByte(contents, '('), bytes.LastIndexByte(contents, ')')
+		if i < 0 || j < i {
+			continue
+		}
+		fields := strings.Fields(string(contents[j+1:]))
+		if len(fields) < 13 {
+			continue
+		}
+		utime, err := strconv.ParseUint(fields[11], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		stime, err := strconv.ParseUint(fields[12], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		consumers[pid] = Consumer{PID: pid, Command: string(contents[i+1 : j]), Ticks: utime + stime}
+	}
+	return consumers, nil
+}
+
+// drain returns the rate at which the battery is discharging in watts, or
+// false if it is not discharging or the rate is not exposed.
+func (b *battery) drain() (float64, bool, error) {
+	status, err := b.read("status")
+	if err != nil {
+		return 0, false, err
+	}
+	if status != "Discharging" {
+		return 0, false, nil
+	}
+	// Some devices report power directly and others only current and
+	// voltage.
+	if microwatts, err := b.readInt("power_now"); err == nil {
+		return float64(microwatts) / 1e6, true, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, false, err
+	}
+	microamps, err := b.readInt("current_now")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	microvolts, err := b.readInt("voltage_now")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return float64(microamps) / 1e6 * float64(microvolts) / 1e6, true, nil
+}
+
+// top samples CPU time over the window and ranks processes by their share
+// of it. The energy impact of each process is estimated by attributing the
+// battery drain in proportion to that share, which ignores other sources
+// of consumption such as the display and radios.
+func top(ctx context.Context, bat *Device, window time.Duration, n int) error {
+	before, err := ticks()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(window):
+	}
+	after, err := ticks()
+	if err != nil {
+		return err
+	}
+	watts, discharging, err := bat.drain()
+	if err != nil {
+		return err
+	}
+
+	consumers := make([]Consumer, 0, len(after))
+	var total uint64
+	for pid, c := range after {
+		// Processes that started during the window are counted in full.
+		if p, ok := before[pid]; ok && p.Ticks <= c.Ticks {
+			c.Ticks -= p.Ticks
+		}
+		if c.Ticks == 0 {
+			continue
+		}
+		total += c.Ticks
+		consumers = append(consumers, c)
+	}
+	slices.SortFunc(consumers, func(a, b Consumer) int {
+		if c := cmp.Compare(b.Ticks, a.Ticks); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.PID, b.PID)
+	})
+	if len(consumers) > n {
+		consumers = consumers[:n]
+	}
+
+	if !discharging {
+		fmt.Fprintln(os.Stderr, "The battery is not discharging so power estimates are unavailable.")
+	}
+	t := Table{Header: []string{"PID", "COMMAND", "SHARE", "POWER"}, Right: []int{0, 2, 3}}
+	for _, c := range consumers {
+		share := float64(c.Ticks) / float64(total)
+		power := "-"
+		if discharging {
+			power = numbers.Watts(share * watts)
+		}
+		t.Append(strconv.Itoa(c.PID), c.Command, numbers.Percent(share*100, 1), power)
+	}
+	return t.Render(os.Stdout)
+}
+
+// cmdTop ranks processes by their share of the drain.
+func cmdTop(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("top", flag.ExitOnError)
+	window := flags.Duration("window", 5*time.Second, ignore)
+	limit := flags.Int("limit", 10, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if *window <= 0 || *limit <= 0 {
+		return userError("The window and limit should be positive.")
+	}
+	return top(ctx, bat, *window, *limit)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a transition of a battery that runs the command given to the
+// daemon with --exec.
+type Event struct {
+	// Kind is status when the charging status changes, level when the
+	// capacity crosses one of the levels given with --exec-at, and
+	// threshold when the charging threshold changes.
+	Kind     string
+	State    State
+	Previous string
+}
+
+// parseLevels parses a comma separated list of capacities such as
+// "20,80".
+func parseLevels(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	levels := make([]int, 0)
+	for _, field := range strings.Split(s, ",") {
+		level, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if level < 1 || level > 100 {
+			return nil, fmt.Errorf("level %d out of range", level)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// transitions returns the events between the previous and current states
+// of the batteries. Batteries that were not known before, or have been
+// removed, have no events.
+func transitions(previous, states []State, levels []int) []Event {
+	events := make([]Event, 0)
+	for _, s := range states {
+		i := -1
+		for j, p := range previous {
+			if p.Battery == s.Battery {
+				i = j
+			}
+		}
+		if i < 0 || s.Status == removed || previous[i].Status == removed {
+			continue
+		}
+		p := previous[i]
+		if p.Status != s.Status {
+			events = append(events, Event{Kind: "status", State: s, Previous: p.Status})
+		}
+		for _, level := range levels {
+			if (p.Capacity < level) != (s.Capacity < level) {
+				events = append(events, Event{Kind: "level", State: s, Previous: strconv.Itoa(p.Capacity)})
+				break
+			}
+		}
+		if p.Threshold != s.Threshold {
+			events = append(events, Event{Kind: "threshold", State: s, Previous: strconv.Itoa(p.Threshold)})
+		}
+	}
+	return events
+}
+
+const (
+	// hookTimeout is how long the command given with --exec may run for an
+	// event before it is killed.
+	hookTimeout = time.Minute
+	// hookQueue is the number of readings whose events may wait for the
+	// command before further ones are dropped.
+	hookQueue = 16
+)
+
+// trigger queues the events for the command given with --exec without
+// waiting for it, so that a slow command does not hold up polling. Events
+// are dropped, with a warning, while the queue is full.
+func (d *daemon) trigger(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	select {
+	case d.hooks <- events:
+	default:
+		d.log.Warn("dropping events, command still running", "operation", "exec", "value", len(events))
+	}
+}
+
+// runHooks runs the command given with --exec for each event queued by
+// trigger, in order, until ctx is cancelled.
+func (d *daemon) runHooks(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case events := <-d.hooks:
+			for _, e := range events {
+				d.hook(ctx, e)
+			}
+		}
+	}
+}
+
+// hook runs the command given with --exec for the event, describing it in
+// environment variables, and kills it after hookTimeout. Failures are
+// logged rather than stopping the daemon.
+func (d *daemon) hook(ctx context.Context, e Event) {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", d.exec)
+	cmd.Env = append(os.Environ(),
+		"BAT_EVENT="+e.Kind,
+		"BAT_DEVICE="+e.State.Battery,
+		"BAT_CAPACITY="+strconv.Itoa(e.State.Capacity),
+		"BAT_STATUS="+e.State.Status,
+		"BAT_LIMIT="+strconv.Itoa(e.State.Threshold),
+		"BAT_PREVIOUS="+e.Previous,
+	)
+	// Background processes started by the command would otherwise keep
+	// its output open, and the wait going, after it is killed.
+	cmd.WaitDelay = time.Second
+	output, err := runner.Run(cmd)
+	if err != nil {
+		d.log.Error("running command failed", "device", e.State.Battery, "operation", "exec",
+			"value", e.Kind, "error", fmt.Errorf("%s: %w", bytes.TrimSpace(output), err))
+		return
+	}
+	d.log.Debug("ran command", "device", e.State.Battery, "operation", "exec", "value", e.Kind)
+}
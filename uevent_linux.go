@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// uevents returns a channel that receives a value whenever the kernel
+// reports a change to a power supply, until ctx is cancelled. Drivers
+// emit these on status changes and, on most models, on every percent of
+// capacity, so listening for them avoids frequent polling.
+func uevents(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// Wrapping the socket in a file integrates it with the runtime poller
+	// so that closing it unblocks the read.
+	f := os.NewFile(uintptr(fd), "uevent")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		buf := make([]byte, 8192)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			// Messages are a header followed by NUL-separated KEY=value
+			// pairs.
+			if !bytes.Contains(buf[:n], []byte("\x00SUBSYSTEM=power_supply\x00")) {
+				continue
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// snapshotted are the commands that read the batteries once, and so may
+// read them from a snapshot. Those that sample them over time are left
+// out.
+var snapshotted = [...]string{"capacity", "health", "id", "line", "metrics", "remaining", "state", "status", "temperature", "voltage"}
+
+// snapshot reads the variables of the battery from its uevent file so that
+// later reads are served from that single, consistent read. Variables
+// missing from it, such as the thresholds on older kernels, are still read
+// from their own files, and those written are dropped from it.
+func (b *battery) snapshot() error {
+	variables, err := b.uevent()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	b.snap = variables
+	return nil
+}
+
+// uevent reads the variables of the battery from its uevent file in a
+// single read, keyed by their sysfs names (e.g. capacity for
+// POWER_SUPPLY_CAPACITY).
+func (b *battery) uevent() (map[string]string, error) {
+	contents, err := sysfs.ReadFile(b.path("uevent"))
+	if err != nil {
+		return nil, err
+	}
+	variables := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, "POWER_SUPPLY_"); ok {
+			variables[strings.ToLower(name)] = value
+		}
+	}
+	return variables, scanner.Err()
+}
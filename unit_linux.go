@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// errInvalidUnit is returned for generated units that systemd would fail
+// to load or run.
+var errInvalidUnit = errors.New("invalid unit")
+
+// unitKeys are the keys of each section that the generated units may use.
+// Any other key is most likely a mistake in a template, which systemd
+// would only log as a warning when the unit is loaded at the next boot.
+var unitKeys = map[string][]string{
+	"Unit":    {"Description", "After", "Before", "Wants", "Requires", "StartLimitBurst", "ConditionPathExistsGlob"},
+	"Service": {"Type", "ExecStart", "Restart", "RemainAfterExit", "StandardInput", "StandardOutput", "RuntimeMaxSec"},
+	"Socket":  {"ListenStream", "SocketMode", "SocketGroup", "Accept"},
+	"Timer":   {"OnCalendar", "AccuracySec", "Persistent"},
+	"Install": {"WantedBy"},
+}
+
+// checkUnit checks a generated unit before it is installed, so that
+// mistakes surface when persisting rather than as a threshold silently
+// not restored after the next boot. Unlike systemd-analyze verify it
+// neither depends on the locale of its messages nor on the state of the
+// other units on the system. It checks the syntax, the keys, that every
+// command is an absolute path to an executable under root with balanced
+// quotes, and that the files written by the commands exist.
+func checkUnit(root, name string, contents []byte) error {
+	invalid := func(n int, format string, args ...any) error {
+		return fmt.Errorf("%s:%d: %s: %w", name, n, fmt.Sprintf(format, args...), errInvalidUnit)
+	}
+	section := ""
+	sections := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if s, ok := strings.CutPrefix(line, "["); ok {
+			s, ok = strings.CutSuffix(s, "]")
+			if _, known := unitKeys[s]; !ok || !known {
+				return invalid(n, "unknown section %q", line)
+			}
+			section = s
+			sections[s] = true
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return invalid(n, "expected key=value, found %q", line)
+		}
+		if section == "" {
+			return invalid(n, "%s outside of a section", key)
+		}
+		if !slices.Contains(unitKeys[section], key) {
+			return invalid(n, "unknown key %s in [%s]", key, section)
+		}
+		if strings.TrimSpace(value) == "" {
+			return invalid(n, "%s is empty", key)
+		}
+		if key == "ExecStart" {
+			if err := checkCommand(root, value); err != nil {
+				return invalid(n, "%v", err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !sections["Unit"] {
+		return fmt.Errorf("%s: missing [Unit]: %w", name, errInvalidUnit)
+	}
+	if sections["Service"] && !bytes.Contains(contents, []byte("\nExecStart=")) {
+		return fmt.Errorf("%s: missing ExecStart: %w", name, errInvalidUnit)
+	}
+	return nil
+}
+
+// checkCommand checks the command line of ExecStart, looking up the
+// program in the tree at root.
+func checkCommand(root, line string) error {
+	if strings.Count(line, "'")%2 != 0 || strings.Count(line, `"`)%2 != 0 {
+		return fmt.Errorf("unbalanced quotes in %q", line)
+	}
+	fields := strings.Fields(line)
+	program := fields[0]
+	if !filepath.IsAbs(program) {
+		return fmt.Errorf("%s is not an absolute path", program)
+	}
+	info, err := os.Stat(filepath.Join(root, program))
+	if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", program)
+	}
+	// Thresholds are restored by redirecting to their sysfs files.
+	for i, field := range fields[:len(fields)-1] {
+		if field == ">" {
+			target := strings.Trim(fields[i+1], `'"`)
+			if _, err := sysfs.Stat(target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
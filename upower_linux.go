@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upowerHistory is where upower keeps the history of each battery, in
+// files named after the kind of reading and an identifier of the battery
+// such as history-rate-5B10W13895-41-3950.dat.
+var upowerHistory = filepath.Join("/", "var", "lib", "upower")
+
+// errNoHistory is returned when there are no upower history files to
+// import.
+var errNoHistory = errors.New("no upower history found")
+
+// importUpower converts the discharge rates in the upower history in dir
+// into one benchmark result per day, labelled label, timed at the last
+// reading of the day and spanning the readings, and stores those not
+// already imported. The runtime is estimated from the current full energy
+// of d. It returns the number of results added.
+//
+// Each line of a history file holds a Unix time, a value and the state of
+// the battery separated by tabs. Only the rates, in watts, have a
+// counterpart in bat: the charge history holds levels rather than the
+// full and design charge behind the health trend.
+func importUpower(d *Device, dir, label string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "history-rate-*.dat"))
+	if err != nil {
+		return 0, err
+	}
+	if len(paths) == 0 {
+		return 0, errNoHistory
+	}
+	type day struct {
+		first, last time.Time
+		total       float64
+		n           int
+	}
+	type rate struct {
+		t     time.Time
+		watts float64
+	}
+	days := make(map[string]*day)
+	for _, path := range paths {
+		rates, err := loadLines(path, func(line string) (rate, bool) {
+			fields := strings.Split(line, "\t")
+			if len(fields) != 3 || fields[2] != "discharging" {
+				return rate{}, false
+			}
+			seconds, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return rate{}, false
+			}
+			watts, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || watts <= 0 {
+				return rate{}, false
+			}
+			return rate{time.Unix(seconds, 0), watts}, true
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range rates {
+			key := r.t.Format(time.DateOnly)
+			dd, ok := days[key]
+			if !ok {
+				dd = &day{first: r.t, last: r.t}
+				days[key] = dd
+			}
+			if r.t.Before(dd.first) {
+				dd.first = r.t
+			}
+			if r.t.After(dd.last) {
+				dd.last = r.t
+			}
+			dd.total += r.watts
+			dd.n++
+		}
+	}
+
+	energy, ok, err := d.energy()
+	if err != nil {
+		return 0, err
+	}
+	rs, err := loadResults()
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, dd := range days {
+		// A single reading says nothing about the drain over a day.
+		if dd.n < 2 || !dd.last.After(dd.first) {
+			continue
+		}
+		r := Result{Time: dd.last, Label: label, Window: dd.last.Sub(dd.first), Watts: dd.total / float64(dd.n)}
+		if ok {
+			r.Runtime = hours(energy / r.Watts)
+		}
+		if slices.ContainsFunc(rs, func(s Result) bool { return s.Label == label && s.Time.Equal(r.Time) }) {
+			continue
+		}
+		rs = append(rs, r)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	slices.SortStableFunc(rs, func(a, b Result) int { return a.Time.Compare(b.Time) })
+	return added, saveResults(rs)
+}
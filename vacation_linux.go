@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// vacation is where the settings replaced by vacation mode are kept until
+// it is turned off.
+var vacation = filepath.Join("/", "var", "lib", "bat", "vacation")
+
+var (
+	errVacation   = errors.New("vacation mode already on")
+	errNoVacation = errors.New("vacation mode not on")
+	errACOnly     = errors.New("threshold applied only on external power")
+)
+
+// Holiday is the settings of a battery from before vacation mode.
+type Holiday struct {
+	Battery   string `json:"battery"`
+	Threshold int    `json:"threshold"`
+	// Behaviour is the charge_behaviour, if the battery has one.
+	Behaviour string `json:"charge_behaviour,omitempty"`
+}
+
+// onVacation reports whether vacation mode is on.
+func onVacation() bool {
+	_, err := os.Stat(vacation)
+	return err == nil
+}
+
+// startVacation records the settings of the batteries with a threshold,
+// then holds them at value and inhibits charging on those that support
+// it. It returns whether charging was inhibited on any of them.
+func startVacation(batteries []*Device, value int) (bool, error) {
+	if onVacation() {
+		return false, errVacation
+	}
+	// The udev rule would raise the threshold again on battery.
+	if expected, err := acOnly(); err != nil || expected != "" {
+		if err != nil {
+			return false, err
+		}
+		return false, errACOnly
+	}
+	holidays := make([]Holiday, 0)
+	for _, b := range batteries {
+		if b.Capabilities()&HasThreshold == 0 {
+			continue
+		}
+		v, err := b.readInt(threshold)
+		if err != nil {
+			return false, err
+		}
+		h := Holiday{Battery: b.Name, Threshold: v}
+		if b.Capabilities()&HasChargeBehaviour != 0 {
+			v, err := b.read("charge_behaviour")
+			if err != nil {
+				return false, err
+			}
+			if m := active.FindStringSubmatch(v); m != nil {
+				h.Behaviour = m[1]
+			}
+		}
+		holidays = append(holidays, h)
+	}
+	if len(holidays) == 0 {
+		return false, errNoThreshold
+	}
+	contents, err := json.Marshal(holidays)
+	if err != nil {
+		return false, err
+	}
+	// The settings are recorded first so that `vacation off` can restore
+	// them should applying the new ones fail part of the way through.
+	if err := writeAtomic(vacation, contents); err != nil {
+		return false, err
+	}
+
+	inhibited := false
+	for _, h := range holidays {
+		b, _ := device(batteries, h.Battery)
+		if err := b.set(threshold, value); err != nil {
+			return inhibited, err
+		}
+		if h.Behaviour == "" {
+			continue
+		}
+		behaviours, err := b.behaviours()
+		if err != nil {
+			return inhibited, err
+		}
+		if slices.Contains(behaviours, "inhibit-charge") {
+			if err := b.write("charge_behaviour", []byte("inhibit-charge")); err != nil {
+				return inhibited, err
+			}
+			inhibited = true
+		}
+	}
+	return inhibited, nil
+}
+
+// endVacation restores the settings recorded by startVacation. Batteries
+// that have since been removed are skipped.
+func endVacation(batteries []*Device) ([]Holiday, error) {
+	contents, err := os.ReadFile(vacation)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, errNoVacation
+		}
+		return nil, err
+	}
+	var holidays []Holiday
+	if err := json.Unmarshal(contents, &holidays); err != nil {
+		return nil, err
+	}
+	restored := make([]Holiday, 0, len(holidays))
+	for _, h := range holidays {
+		b, ok := device(batteries, h.Battery)
+		if !ok {
+			continue
+		}
+		if h.Behaviour != "" {
+			if err := b.write("charge_behaviour", []byte(h.Behaviour)); err != nil {
+				return restored, err
+			}
+		}
+		if err := b.set(threshold, h.Threshold); err != nil {
+			return restored, err
+		}
+		restored = append(restored, h)
+	}
+	return restored, os.Remove(vacation)
+}
+
+// cmdVacation turns vacation mode on or off.
+func cmdVacation(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("vacation", flag.ExitOnError)
+	value := flags.Int("threshold", 0, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		return errUsage
+	}
+	switch flags.Arg(0) {
+	case "":
+		if onVacation() {
+			fmt.Println("on")
+		} else {
+			fmt.Println("off")
+		}
+	case "on":
+		// The flag takes precedence over the configuration file, which
+		// is optional.
+		if *value == 0 {
+			c, _ := mustConfig(ctx)
+			*value = c.VacationThreshold
+		}
+		if *value < 1 || *value > 100 {
+			return userError("Threshold value should be between 1 and 100.")
+		}
+		if q, ok := machineQuirk(); ok && !slices.Contains(q.Values, *value) {
+			return userError(q.message(*value))
+		}
+		inhibited, err := startVacation(batteries, *value)
+		switch {
+		case errors.Is(err, errVacation):
+			return userError("Vacation mode is already on.")
+		case errors.Is(err, errACOnly):
+			return userError("Vacation mode cannot be combined with `threshold --on-ac-only`. Set a\n" +
+				"plain threshold first.")
+		}
+		if err != nil {
+			return err
+		}
+		for _, b := range batteries {
+			if b.Capabilities()&HasThreshold != 0 {
+				audit(b.Name, *value)
+			}
+		}
+		if inhibited {
+			fmt.Printf("Vacation mode on. The charging threshold is %d%% and charging is inhibited.\n", *value)
+		} else {
+			fmt.Printf("Vacation mode on. The charging threshold is %d%%.\n", *value)
+		}
+		updatePersisted(ctx, batteries)
+		fmt.Println("Run `sudo bat vacation off` to restore the previous settings.")
+	case "off":
+		restored, err := endVacation(batteries)
+		if errors.Is(err, errNoVacation) {
+			return userError("Vacation mode is not on.")
+		}
+		for _, h := range restored {
+			audit(h.Battery, h.Threshold)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("Vacation mode off. The previous settings were restored.")
+		updatePersisted(ctx, batteries)
+	default:
+		return userError("Vacation mode should be turned `on` or `off`.")
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Verifier is the configuration of the unit that checks that the charging
+// thresholds survived a suspend or hibernate cycle.
+type Verifier struct {
+	Events, Units             []string
+	Expected, Path, Scheduled string
+}
+
+//go:embed verify.service
+var verifierUnit string
+
+// resumes lists the events after which the firmware may have reset the
+// threshold.
+var resumes = [...]string{"hibernate", "hybrid-sleep", "suspend", "suspend-then-hibernate"}
+
+// verifier returns the name of the verification unit.
+func (u Units) verifier() string {
+	return u.Prefix + "verify.service"
+}
+
+// installVerifier writes and enables a unit that compares the charging
+// thresholds against their current values after every resume. It is
+// ordered after the persistence units so that it checks their result.
+func installVerifier(ctx context.Context, units Units, batteries []*Device) error {
+	contents, err := renderVerifier(units, batteries)
+	if err != nil {
+		return err
+	}
+	if err := checkUnit("/", units.verifier(), contents); err != nil {
+		return err
+	}
+	name := filepath.Join(units.Dir, units.verifier())
+	if err := os.WriteFile(name, contents, 0o644); err != nil {
+		os.Remove(name)
+		return err
+	}
+	if err := relabel(ctx, name); err != nil {
+		return err
+	}
+	_, err = systemctl(ctx, "enable", units.verifier())
+	return err
+}
+
+// updateVerifier rewrites the verification unit, if installed, once the
+// thresholds have changed, since it would otherwise warn about the ones it
+// was installed with.
+func updateVerifier(ctx context.Context, units Units, batteries []*Device) ([]Outcome, error) {
+	name := filepath.Join(units.Dir, units.verifier())
+	current, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	contents, err := renderVerifier(units, batteries)
+	if err != nil || bytes.Equal(current, contents) {
+		return nil, err
+	}
+	if err := checkUnit("/", units.verifier(), contents); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(name, contents, 0o644); err != nil {
+		return nil, err
+	}
+	if err := relabel(ctx, name); err != nil {
+		return nil, err
+	}
+	outcomes := []Outcome{{Unit: units.verifier(), Action: "updated"}}
+	_, err = systemctl(ctx, "daemon-reload")
+	return outcomes, err
+}
+
+// renderVerifier returns the contents of the verification unit. It
+// expects the current thresholds, or those applied on external power if
+// `threshold --on-ac-only` is in effect, which verify then adjusts to the
+// power source.
+func renderVerifier(units Units, batteries []*Device) ([]byte, error) {
+	expected, err := acOnly()
+	if err != nil {
+		return nil, err
+	}
+	if expected == "" {
+		pairs := make([]string, 0, len(batteries))
+		for _, b := range batteries {
+			if b.Capabilities()&HasThreshold == 0 {
+				continue
+			}
+			v, err := b.read(threshold)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, b.Name+"="+v)
+		}
+		if len(pairs) == 0 {
+			return nil, errNoThreshold
+		}
+		expected = strings.Join(pairs, ",")
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	v := Verifier{Events: resumes[:], Expected: expected, Path: path, Scheduled: scheduled}
+	for _, event := range resumes {
+		v.Units = append(v.Units, units.name(event))
+	}
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("verify").Parse(verifierUnit))
+	if err := tmpl.Execute(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// removeVerifier disables and removes the verification unit if it is
+// installed.
+func removeVerifier(ctx context.Context, units Units) (bool, error) {
+	name := filepath.Join(units.Dir, units.verifier())
+	if _, err := os.Stat(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := systemctl(ctx, "disable", units.verifier()); err != nil {
+		return false, err
+	}
+	return true, os.Remove(name)
+}
+
+// verify compares the charging threshold of each battery against the
+// expected values, given in the form BAT0=80,BAT1=90, and returns a
+// warning for each mismatch. While `threshold --on-ac-only` is in effect
+// the thresholds are expected to be 100 on battery power.
+func verify(batteries []*Device, expected string) ([]string, error) {
+	rule, err := acOnly()
+	if err != nil {
+		return nil, err
+	}
+	unplugged := false
+	if rule != "" {
+		srcs, err := sources()
+		if err != nil {
+			return nil, err
+		}
+		unplugged = online(srcs) == ""
+	}
+	warnings := make([]string, 0)
+	for _, pair := range strings.Split(expected, ",") {
+		name, want, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed expectation %q", pair)
+		}
+		d, ok := device(batteries, name)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s is missing.", name))
+			continue
+		}
+		got, err := d.read(threshold)
+		if err != nil {
+			return nil, err
+		}
+		if unplugged {
+			want = "100"
+		}
+		if got != want {
+			warnings = append(warnings, fmt.Sprintf("%s charging threshold is %s%% instead of %s%%.", name, got, want))
+		}
+	}
+	return warnings, nil
+}
+
+// cmdVerify warns about thresholds that did not survive a resume, for the
+// verification unit.
+func cmdVerify(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	// Invoked by the unit installed with `threshold
+	// --verify-after-resume`. The priority prefix marks the output as
+	// a warning in the journal.
+	if len(args) != 1 {
+		return errUsage
+	}
+	warnings, err := verify(batteries, args[0])
+	if err != nil {
+		panic(err)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "<4>"+warning)
+	}
+	if len(warnings) > 0 {
+		return errFailed
+	}
+	return nil
+}
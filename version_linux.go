@@ -0,0 +1,50 @@
+package main
+
+import (
+	"runtime"
+	rtdebug "runtime/debug"
+	"strings"
+)
+
+// Build describes the provenance of the binary for bug reports.
+type Build struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	Date      string   `json:"date,omitempty"`
+	Modified  bool     `json:"modified,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	Tags      []string `json:"tags"`
+}
+
+// build returns the metadata of the binary. The version is the tag set by
+// the Makefile and the rest is recorded by the Go toolchain, which omits
+// the VCS fields when building outside of a checkout.
+func build() Build {
+	b := Build{
+		Version:   tag,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Tags:      make([]string, 0),
+	}
+	info, ok := rtdebug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Commit = s.Value
+		case "vcs.time":
+			b.Date = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		case "-tags":
+			b.Tags = strings.Split(s.Value, ",")
+		}
+	}
+	if b.Version == "" {
+		b.Version = info.Main.Version
+	}
+	return b
+}
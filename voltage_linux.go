@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Voltages are the readings of a battery in volts. Design values that are
+// not exposed are zero.
+type Voltages struct {
+	Now, MinDesign, MaxDesign float64
+}
+
+// voltages reads the current and design voltages.
+func (d *Device) voltages() (Voltages, error) {
+	var v Voltages
+	for _, reading := range [...]struct {
+		variable string
+		value    *float64
+	}{
+		{"voltage_now", &v.Now},
+		{"voltage_min_design", &v.MinDesign},
+		{"voltage_max_design", &v.MaxDesign},
+	} {
+		microvolts, err := d.readInt(reading.variable)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && reading.variable != "voltage_now" {
+				continue
+			}
+			return v, err
+		}
+		*reading.value = float64(microvolts) / 1e6
+	}
+	return v, nil
+}
+
+// anomalies returns warnings for readings that deviate from the design
+// range. A charged battery at rest should sit well above its minimum
+// design voltage, so a low reading is an early sign of a failing cell.
+func (v Voltages) anomalies(status string, level int) []string {
+	warnings := make([]string, 0)
+	if v.MinDesign > 0 && v.Now < v.MinDesign {
+		warnings = append(warnings, "The voltage is below the design minimum.")
+	}
+	if v.MaxDesign > 0 && v.Now > v.MaxDesign*1.02 {
+		warnings = append(warnings, "The voltage is above the design maximum.")
+	}
+	resting := status != "Charging" && status != "Discharging"
+	if resting && level >= 90 && v.MinDesign > 0 && v.Now < v.MinDesign*1.05 {
+		warnings = append(warnings, "The voltage is low for a charged battery at rest, which may indicate a\nfailing cell.")
+	}
+	return warnings
+}
+
+// cmdVoltage prints the current and design voltages.
+func cmdVoltage(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	if bat.Capabilities()&HasVoltage == 0 {
+		return userError(unsupported(bat, HasVoltage))
+	}
+	v, err := bat.voltages()
+	if err != nil {
+		panic(err)
+	}
+	status, err := bat.read("status")
+	if err != nil {
+		panic(err)
+	}
+	level, err := bat.readInt("capacity")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Voltage: %s\n", numbers.Volts(v.Now))
+	if v.MinDesign > 0 {
+		fmt.Printf("Design minimum: %s\n", numbers.Volts(v.MinDesign))
+	}
+	if v.MaxDesign > 0 {
+		fmt.Printf("Design maximum: %s\n", numbers.Volts(v.MaxDesign))
+	}
+	for _, warning := range v.anomalies(status, level) {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+	return nil
+}
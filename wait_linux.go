@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// waitInterval is the time between probes of the power supplies while
+// waiting for a battery to be registered.
+const waitInterval = 250 * time.Millisecond
+
+// waitForDevice probes the power supplies until the battery with the given
+// name, or any battery if it is empty, is present with a charging
+// threshold or the timeout elapses. Early in boot the battery, or the
+// vendor module that adds the threshold to it, may not be registered yet.
+// The batteries found by the last probe are returned either way so that
+// the caller reports what is missing.
+func waitForDevice(ctx context.Context, name string, timeout time.Duration) ([]*Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(waitInterval)
+	defer ticker.Stop()
+	for {
+		devs, err := devices()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devs {
+			if (name == "" || d.Name == name) && d.present() && d.Capabilities()&HasThreshold != 0 {
+				return devs, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return devs, nil
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/sys/unix"
+)
+
+// which prints the paths and mechanisms bat resolved for the device on
+// this machine, for inclusion in bug reports. As Markdown the table also
+// holds the details maintainers ask for when judging compatibility: the
+// versions of bat and the kernel, the machine and the capabilities of the
+// battery.
+func which(ctx context.Context, w io.Writer, d *Device, units Units, markdown bool) error {
+	exists := func(path string) string {
+		if _, err := sysfs.Stat(path); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return "not found"
+			}
+			return err.Error()
+		}
+		return path
+	}
+	persistence := "systemd " + filepath.Join(units.Dir, units.name("*"))
+	if elogind() {
+		persistence = "elogind " + filepath.Join(hooks, "bat")
+	} else if _, err := os.Stat(sysext); err == nil {
+		persistence = "sysext " + sysext
+	}
+	drivers, err := d.drivers()
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(drivers))
+	for i, driver := range drivers {
+		names[i] = driver.String()
+	}
+	backend := "sysfs"
+	if legacyDevice(d) {
+		backend = "procfs (legacy ACPI, read-only)"
+	} else if len(names) > 0 {
+		backend += " (" + strings.Join(names, ", ") + ")"
+	}
+	found := make([]string, 0)
+	for _, path := range configFiles() {
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, path)
+		}
+	}
+	config := strings.Join(found, ", ")
+	if len(found) == 0 {
+		config = strings.Join(configFiles(), ", ") + " (not found)"
+	}
+
+	rows := [][2]string{
+		{"Battery", d.root},
+		{"Threshold", exists(d.path(threshold))},
+		{"Start threshold", exists(d.path(startThreshold))},
+		{"Charge behaviour", exists(d.path("charge_behaviour"))},
+		{"Backend", backend},
+		{"Persistence", persistence},
+		{"Configuration", config},
+	}
+	if d.Capabilities()&HasAlarm != 0 {
+		if alarm, err := d.alarm(); err == nil {
+			rows = append(rows, [2]string{"Alarm", fmt.Sprintf("%d%%", alarm)})
+		}
+	}
+	if limit, setting, ok, err := dellLimit(); err == nil && ok {
+		row := fmt.Sprintf("%d%% (%s)", limit, setting)
+		if v, err := d.readInt(threshold); err == nil && v != limit {
+			row += fmt.Sprintf(", differs from the threshold of %d%%", v)
+		}
+		rows = append(rows, [2]string{"BIOS charge limit", row})
+	}
+	if settings, err := hpSettings(); err == nil {
+		for _, s := range settings {
+			rows = append(rows, [2]string{"HP " + s.Name, s.Current})
+		}
+	}
+	// ectool fails without root, in which case the rows are left out.
+	if state, err := ecState(ctx); err == nil {
+		for _, row := range state {
+			rows = append(rows, [2]string{"EC " + row[0], row[1]})
+		}
+	} else if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !markdown {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, row := range rows {
+			fmt.Fprintf(tw, "%s:\t%s\n", row[0], row[1])
+		}
+		return tw.Flush()
+	}
+
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return err
+	}
+	rows = append([][2]string{
+		{"bat", build().Version},
+		{"Kernel", unix.ByteSliceToString(uname.Release[:])},
+	}, rows...)
+	for _, variable := range identifying {
+		if v, err := readString(filepath.Join(dmi, variable)); err == nil {
+			rows = append(rows, [2]string{"DMI " + variable, v})
+		}
+	}
+	// The serial number is left out since the output is meant to be
+	// published.
+	for _, variable := range [...]string{"manufacturer", "model_name", "technology"} {
+		if v, err := d.read(variable); err == nil {
+			rows = append(rows, [2]string{"Battery " + strings.ReplaceAll(variable, "_", " "), v})
+		}
+	}
+	rows = append(rows, [2]string{"Capabilities", d.Capabilities().String()})
+	for i := range rows {
+		rows[i][1] = "`" + rows[i][1] + "`"
+	}
+	return markdownTable(w, [2]string{"Property", "Value"}, rows)
+}
+
+// markdownTable writes the rows as a Markdown table under the header, for
+// output meant to be pasted into an issue.
+func markdownTable(w io.Writer, header [2]string, rows [][2]string) error {
+	// Pipes would otherwise end the cell.
+	escape := strings.NewReplacer("|", "\\|", "\n", " ").Replace
+	var b strings.Builder
+	fmt.Fprintf(&b, "| %s | %s |\n| --- | --- |\n", header[0], header[1])
+	for _, row := range rows {
+		fmt.Fprintf(&b, "| %s | %s |\n", escape(row[0]), escape(row[1]))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// cmdWhich prints the paths and mechanisms resolved for the battery.
+func cmdWhich(ctx context.Context, bat *Device, batteries []*Device, args []string) error {
+	flags := flag.NewFlagSet("which", flag.ExitOnError)
+	units := defaultUnits
+	flags.StringVar(&units.Dir, "unit-dir", units.Dir, ignore)
+	flags.StringVar(&units.Prefix, "unit-prefix", units.Prefix, ignore)
+	markdown := flags.Bool("markdown", false, ignore)
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+	if err := which(ctx, os.Stdout, bat, units, *markdown); err != nil {
+		panic(err)
+	}
+	return nil
+}